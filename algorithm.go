@@ -0,0 +1,81 @@
+package macaroon
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// Algorithm identifies the keyed hash algorithm used to
+// calculate the signature chain of a macaroon.
+//
+// Only V3 macaroons record their algorithm; V1 and V2
+// macaroons always use HMACSHA256. The key derivation
+// applied to a root key (see TraceMakeKey) and the encryption
+// of third party caveat verification ids are the same
+// for all algorithms, so a macaroon may be discharged
+// by macaroons that use a different algorithm.
+type Algorithm byte
+
+const (
+	// HMACSHA256 specifies HMAC with SHA-256. It is the
+	// algorithm used by V1 and V2 macaroons and by
+	// other macaroon implementations.
+	HMACSHA256 Algorithm = 0
+
+	// HMACSHA512_256 specifies HMAC with SHA-512/256
+	// as defined in FIPS 180-4.
+	HMACSHA512_256 Algorithm = 1
+)
+
+var algorithms = []struct {
+	name    string
+	newHash func() hash.Hash
+}{
+	HMACSHA256: {
+		name:    "hmac-sha256",
+		newHash: sha256.New,
+	},
+	HMACSHA512_256: {
+		name:    "hmac-sha512-256",
+		newHash: sha512.New512_256,
+	},
+}
+
+// String returns the name of the algorithm;
+// for example HMACSHA256 formats as "hmac-sha256".
+func (alg Algorithm) String() string {
+	if !alg.valid() {
+		return fmt.Sprintf("algorithm%d", byte(alg))
+	}
+	return algorithms[alg].name
+}
+
+// valid reports whether alg is a known algorithm.
+func (alg Algorithm) valid() bool {
+	return int(alg) < len(algorithms)
+}
+
+// algorithmByName returns the algorithm with the given name,
+// as returned by Algorithm.String.
+func algorithmByName(name string) (Algorithm, error) {
+	for alg, a := range algorithms {
+		if a.name == name {
+			return Algorithm(alg), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown algorithm %q", name)
+}
+
+// checkAlgorithm returns an error if alg cannot be used
+// for a macaroon with the given version.
+func checkAlgorithm(version Version, alg Algorithm) error {
+	if !alg.valid() {
+		return fmt.Errorf("unknown algorithm %v", alg)
+	}
+	if version < V3 && alg != HMACSHA256 {
+		return fmt.Errorf("algorithm %v not supported by %v macaroon", alg, version)
+	}
+	return nil
+}
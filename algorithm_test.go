@@ -0,0 +1,247 @@
+package macaroon_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+func TestAlgorithmString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(macaroon.HMACSHA256.String(), qt.Equals, "hmac-sha256")
+	c.Assert(macaroon.HMACSHA512_256.String(), qt.Equals, "hmac-sha512-256")
+	c.Assert(macaroon.Algorithm(99).String(), qt.Equals, "algorithm99")
+}
+
+func TestNewWithAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m, err := macaroon.NewWithAlgorithm(rootKey, []byte("some id"), "a location", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Version(), qt.Equals, macaroon.V3)
+	c.Assert(m.Algorithm(), qt.Equals, macaroon.HMACSHA512_256)
+
+	// Calculate the expected signature independently.
+	h := hmac.New(sha256.New, []byte("macaroons-key-generator"))
+	h.Write(rootKey)
+	h = hmac.New(sha512.New512_256, h.Sum(nil))
+	h.Write([]byte("some id"))
+	c.Assert(m.Signature(), qt.DeepEquals, h.Sum(nil))
+
+	// The default algorithm produces the same signature as V2.
+	m3, err := macaroon.NewWithAlgorithm(rootKey, []byte("some id"), "a location", macaroon.V3, macaroon.HMACSHA256)
+	c.Assert(err, qt.Equals, nil)
+	m2 := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.V2)
+	c.Assert(m3.Signature(), qt.DeepEquals, m2.Signature())
+	c.Assert(m2.Algorithm(), qt.Equals, macaroon.HMACSHA256)
+}
+
+var newWithAlgorithmErrorTests = []struct {
+	about       string
+	version     macaroon.Version
+	alg         macaroon.Algorithm
+	expectError string
+}{{
+	about:       "v1 with non-default algorithm",
+	version:     macaroon.V1,
+	alg:         macaroon.HMACSHA512_256,
+	expectError: `algorithm hmac-sha512-256 not supported by v1 macaroon`,
+}, {
+	about:       "v2 with non-default algorithm",
+	version:     macaroon.V2,
+	alg:         macaroon.HMACSHA512_256,
+	expectError: `algorithm hmac-sha512-256 not supported by v2 macaroon`,
+}, {
+	about:       "unknown algorithm",
+	version:     macaroon.V3,
+	alg:         macaroon.Algorithm(99),
+	expectError: `unknown algorithm algorithm99`,
+}, {
+	about:       "unknown version",
+	version:     4,
+	alg:         macaroon.HMACSHA256,
+	expectError: `invalid version v4`,
+}}
+
+func TestNewWithAlgorithmError(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range newWithAlgorithmErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		m, err := macaroon.NewWithAlgorithm([]byte("secret"), []byte("some id"), "", test.version, test.alg)
+		c.Check(err, qt.ErrorMatches, test.expectError)
+		c.Check(m, qt.IsNil)
+	}
+}
+
+// makeAlgorithmMacaroons returns a primary macaroon using
+// HMACSHA512_256 with a third party caveat discharged by
+// a macaroon using the default algorithm, and vice versa
+// for a caveat on the discharge.
+func makeAlgorithmMacaroons(c *qt.C) (rootKey []byte, ms macaroon.Slice) {
+	rootKey = []byte("root key")
+	m0, err := macaroon.NewWithAlgorithm(rootKey, []byte("root id"), "", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	err = m0.AddFirstPartyCaveat([]byte("wonderful"))
+	c.Assert(err, qt.Equals, nil)
+	err = m0.AddThirdPartyCaveat([]byte("bob key"), []byte("bob"), "bob-location")
+	c.Assert(err, qt.Equals, nil)
+
+	m1 := macaroon.MustNew([]byte("bob key"), []byte("bob"), "", macaroon.V2)
+	err = m1.AddThirdPartyCaveat([]byte("barbara key"), []byte("barbara"), "barbara-location")
+	c.Assert(err, qt.Equals, nil)
+
+	m2, err := macaroon.NewWithAlgorithm([]byte("barbara key"), []byte("barbara"), "", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	err = m2.AddFirstPartyCaveat([]byte("splendid"))
+	c.Assert(err, qt.Equals, nil)
+
+	m1.Bind(m0.Signature())
+	m2.Bind(m0.Signature())
+	return rootKey, macaroon.Slice{m0, m1, m2}
+}
+
+func TestVerifyWithAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	rootKey, ms := makeAlgorithmMacaroons(c)
+	conds, err := ms[0].VerifySignature(rootKey, ms[1:])
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(conds, qt.DeepEquals, []string{"wonderful", "splendid"})
+
+	_, err = ms[0].VerifySignature([]byte("wrong"), ms[1:])
+	c.Assert(err, qt.ErrorMatches, `failed to decrypt caveat 1 signature: decryption failure`)
+}
+
+func TestVerifyWithChangedAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m, err := macaroon.NewWithAlgorithm(rootKey, []byte("some id"), "", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+
+	// Relabeling the algorithm must invalidate the signature.
+	data[1] = byte(macaroon.HMACSHA256)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, qt.Equals, nil)
+	_, err = m1.VerifySignature(rootKey, nil)
+	c.Assert(err, qt.ErrorMatches, `signature mismatch after caveat verification`)
+}
+
+func TestTraceVerifyWithAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	rootKey, ms := makeAlgorithmMacaroons(c)
+	traces, err := ms[0].TraceVerify(rootKey, ms[1:])
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(traces, qt.HasLen, len(ms))
+	for i, m := range ms {
+		c.Assert(traces[i].Algorithm, qt.Equals, m.Algorithm(), qt.Commentf("macaroon %d", i))
+		r := traces[i].Results()
+		c.Assert(b64str(r[len(r)-1]), qt.Equals, b64str(m.Signature()), qt.Commentf("macaroon %d", i))
+	}
+}
+
+func TestAlgorithmBinaryRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	_, ms := makeAlgorithmMacaroons(c)
+	data, err := ms[0].MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(data[0:2], qt.DeepEquals, []byte{3, byte(macaroon.HMACSHA512_256)})
+
+	var m macaroon.Macaroon
+	err = m.UnmarshalBinary(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Equal(ms[0]), qt.Equals, true)
+	c.Assert(m.Version(), qt.Equals, macaroon.V3)
+	c.Assert(m.Algorithm(), qt.Equals, macaroon.HMACSHA512_256)
+
+	data, err = ms.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	var ms1 macaroon.Slice
+	err = ms1.UnmarshalBinary(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms1, qt.HasLen, len(ms))
+	for i := range ms {
+		c.Assert(ms1[i].Equal(ms[i]), qt.Equals, true, qt.Commentf("macaroon %d", i))
+	}
+}
+
+func TestAlgorithmBinaryError(t *testing.T) {
+	c := qt.New(t)
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary([]byte{3})
+	c.Assert(err, qt.ErrorMatches, `unmarshal v3: no algorithm found`)
+	err = m.UnmarshalBinary([]byte{3, 99})
+	c.Assert(err, qt.ErrorMatches, `unmarshal v3: unknown algorithm algorithm99`)
+}
+
+func TestAlgorithmJSONRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	for _, alg := range []macaroon.Algorithm{macaroon.HMACSHA256, macaroon.HMACSHA512_256} {
+		c.Logf("algorithm %v", alg)
+		m, err := macaroon.NewWithAlgorithm([]byte("secret"), []byte("some id"), "", macaroon.V3, alg)
+		c.Assert(err, qt.Equals, nil)
+		data, err := json.Marshal(m)
+		c.Assert(err, qt.Equals, nil)
+		var obj map[string]interface{}
+		err = json.Unmarshal(data, &obj)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(obj["alg"], qt.Equals, alg.String())
+
+		var m1 macaroon.Macaroon
+		err = json.Unmarshal(data, &m1)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+		c.Assert(m1.Version(), qt.Equals, macaroon.V3)
+	}
+}
+
+func TestAlgorithmJSONError(t *testing.T) {
+	c := qt.New(t)
+	var m macaroon.Macaroon
+	err := json.Unmarshal([]byte(`{"alg": "md5", "i": "x"}`), &m)
+	c.Assert(err, qt.ErrorMatches, `unknown algorithm "md5"`)
+}
+
+func TestUnmarshalResetsAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	m, err := macaroon.NewWithAlgorithm([]byte("secret"), []byte("some id"), "", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	data, err := macaroon.MustNew([]byte("secret"), []byte("other id"), "", macaroon.V1).MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	err = m.UnmarshalBinary(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Version(), qt.Equals, macaroon.V1)
+	c.Assert(m.Algorithm(), qt.Equals, macaroon.HMACSHA256)
+}
+
+func TestDraftAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	d := macaroon.Draft{
+		Id:        []byte("some id"),
+		Version:   macaroon.V3,
+		Algorithm: macaroon.HMACSHA512_256,
+	}
+	d.AddFirstPartyCaveat([]byte("a caveat"))
+	m, err := d.Finalize([]byte("secret"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Algorithm(), qt.Equals, macaroon.HMACSHA512_256)
+
+	m1, err := macaroon.NewWithAlgorithm([]byte("secret"), []byte("some id"), "", macaroon.V3, macaroon.HMACSHA512_256)
+	c.Assert(err, qt.Equals, nil)
+	err = m1.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Equal(m1), qt.Equals, true)
+
+	diff, err := m1.Diff(m)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(diff.IsAttenuation, qt.Equals, true)
+}
@@ -0,0 +1,72 @@
+package macaroon
+
+import (
+	"fmt"
+)
+
+// base45Alphabet holds the base45 alphabet defined by RFC 9285.
+// All its characters are in the QR code alphanumeric set.
+const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+var base45Values = func() [256]int8 {
+	var vals [256]int8
+	for i := range vals {
+		vals[i] = -1
+	}
+	for i := 0; i < len(base45Alphabet); i++ {
+		vals[base45Alphabet[i]] = int8(i)
+	}
+	return vals
+}()
+
+// base45Encode returns the base45 encoding of data as defined
+// by RFC 9285.
+func base45Encode(data []byte) string {
+	buf := make([]byte, 0, (len(data)+1)/2*3)
+	for len(data) >= 2 {
+		n := int(data[0])<<8 | int(data[1])
+		buf = append(buf, base45Alphabet[n%45], base45Alphabet[n/45%45], base45Alphabet[n/(45*45)])
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		n := int(data[0])
+		buf = append(buf, base45Alphabet[n%45], base45Alphabet[n/45])
+	}
+	return string(buf)
+}
+
+// base45Decode decodes the RFC 9285 base45 encoding of data.
+func base45Decode(data []byte) ([]byte, error) {
+	if len(data)%3 == 1 {
+		return nil, fmt.Errorf("illegal base45 data length %d", len(data))
+	}
+	buf := make([]byte, 0, len(data)/3*2+1)
+	for i := 0; i < len(data); i += 3 {
+		group := data[i:]
+		if len(group) > 3 {
+			group = group[:3]
+		}
+		n := 0
+		mul := 1
+		for j, b := range group {
+			v := base45Values[b]
+			if v < 0 {
+				return nil, fmt.Errorf("illegal base45 data at input byte %d", i+j)
+			}
+			n += int(v) * mul
+			mul *= 45
+		}
+		if len(group) == 3 {
+			if n > 0xffff {
+				return nil, fmt.Errorf("illegal base45 data at input byte %d", i)
+			}
+			buf = append(buf, byte(n>>8), byte(n))
+		} else {
+			if n > 0xff {
+				return nil, fmt.Errorf("illegal base45 data at input byte %d", i)
+			}
+			buf = append(buf, byte(n))
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,67 @@
+package macaroon
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var base45Tests = []struct {
+	data    string
+	encoded string
+}{{
+	data:    "",
+	encoded: "",
+}, {
+	data:    "AB",
+	encoded: "BB8",
+}, {
+	data:    "Hello!!",
+	encoded: "%69 VD92EX0",
+}, {
+	data:    "base-45",
+	encoded: "UJCLQE7W581",
+}, {
+	data:    "ietf!",
+	encoded: "QED8WEX0",
+}, {
+	data:    "\xff\xff\xff",
+	encoded: "FGWU5",
+}}
+
+func TestBase45(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range base45Tests {
+		c.Logf("test %q", test.data)
+		c.Check(base45Encode([]byte(test.data)), qt.Equals, test.encoded)
+		data, err := base45Decode([]byte(test.encoded))
+		c.Assert(err, qt.Equals, nil)
+		c.Check(string(data), qt.Equals, test.data)
+	}
+}
+
+var base45DecodeErrorTests = []struct {
+	encoded     string
+	expectError string
+}{{
+	encoded:     "GGW",
+	expectError: `illegal base45 data at input byte 0`,
+}, {
+	encoded:     "BB8!B",
+	expectError: `illegal base45 data at input byte 3`,
+}, {
+	encoded:     "BB8A",
+	expectError: `illegal base45 data length 4`,
+}, {
+	encoded:     "BB8:6",
+	expectError: `illegal base45 data at input byte 3`,
+}}
+
+func TestBase45DecodeError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range base45DecodeErrorTests {
+		c.Logf("test %q", test.encoded)
+		_, err := base45Decode([]byte(test.encoded))
+		c.Check(err, qt.ErrorMatches, test.expectError)
+	}
+}
@@ -3,6 +3,7 @@ package macaroon_test
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 	"testing"
 
 	"gopkg.in/macaroon.v2"
@@ -23,7 +24,7 @@ func BenchmarkNew(b *testing.B) {
 	loc := base64.StdEncoding.EncodeToString(randomBytes(40))
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
-		MustNew(rootKey, id, loc, macaroon.LatestVersion)
+		macaroon.MustNew(rootKey, id, loc, macaroon.LatestVersion)
 	}
 }
 
@@ -34,7 +35,7 @@ func BenchmarkAddCaveat(b *testing.B) {
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
 		b.StopTimer()
-		m := MustNew(rootKey, id, loc, macaroon.LatestVersion)
+		m := macaroon.MustNew(rootKey, id, loc, macaroon.LatestVersion)
 		b.StartTimer()
 		m.AddFirstPartyCaveat([]byte("some caveat stuff"))
 	}
@@ -68,11 +69,29 @@ func BenchmarkVerifySmall(b *testing.B) {
 	}})
 }
 
+func BenchmarkVerifyManyDischarges(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			benchmarkVerify(b, manyDischargesMacaroons(n))
+		})
+	}
+}
+
+func BenchmarkVerifyDeepDischarges(b *testing.B) {
+	for _, n := range []int{5, 20, 50} {
+		b.Run(fmt.Sprintf("depth=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			benchmarkVerify(b, deepDischargesMacaroons(n))
+		})
+	}
+}
+
 func BenchmarkMarshalJSON(b *testing.B) {
 	rootKey := randomBytes(24)
 	id := []byte(base64.StdEncoding.EncodeToString(randomBytes(100)))
 	loc := base64.StdEncoding.EncodeToString(randomBytes(40))
-	m := MustNew(rootKey, id, loc, macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, id, loc, macaroon.LatestVersion)
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
 		_, err := m.MarshalJSON()
@@ -82,19 +101,11 @@ func BenchmarkMarshalJSON(b *testing.B) {
 	}
 }
 
-func MustNew(rootKey, id []byte, loc string, vers macaroon.Version) *macaroon.Macaroon {
-	m, err := macaroon.New(rootKey, id, loc, vers)
-	if err != nil {
-		panic(err)
-	}
-	return m
-}
-
 func BenchmarkUnmarshalJSON(b *testing.B) {
 	rootKey := randomBytes(24)
 	id := []byte(base64.StdEncoding.EncodeToString(randomBytes(100)))
 	loc := base64.StdEncoding.EncodeToString(randomBytes(40))
-	m := MustNew(rootKey, id, loc, macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, id, loc, macaroon.LatestVersion)
 	data, err := m.MarshalJSON()
 	if err != nil {
 		b.Fatalf("cannot marshal JSON: %v", err)
@@ -107,3 +118,51 @@ func BenchmarkUnmarshalJSON(b *testing.B) {
 		}
 	}
 }
+
+func benchmarkUnmarshalBinary(b *testing.B, unmarshal func(m *macaroon.Macaroon, data []byte) error) {
+	rootKey := randomBytes(24)
+	id := []byte(base64.StdEncoding.EncodeToString(randomBytes(100)))
+	loc := base64.StdEncoding.EncodeToString(randomBytes(40))
+	m := macaroon.MustNew(rootKey, id, loc, macaroon.LatestVersion)
+	for i := 0; i < 10; i++ {
+		m.AddFirstPartyCaveat([]byte(fmt.Sprintf("caveat %d", i)))
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		b.Fatalf("cannot marshal binary: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		var m macaroon.Macaroon
+		err := unmarshal(&m, data)
+		if err != nil {
+			b.Fatalf("cannot unmarshal binary: %v", err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	benchmarkUnmarshalBinary(b, (*macaroon.Macaroon).UnmarshalBinary)
+}
+
+func BenchmarkUnmarshalBinaryNoCopy(b *testing.B) {
+	benchmarkUnmarshalBinary(b, (*macaroon.Macaroon).UnmarshalBinaryNoCopy)
+}
+
+func BenchmarkSliceUnmarshalBinary(b *testing.B) {
+	_, macaroons := makeMacaroons(multilevelThirdPartyCaveatMacaroons)
+	data, err := macaroons.MarshalBinary()
+	if err != nil {
+		b.Fatalf("cannot marshal binary: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := b.N - 1; i >= 0; i-- {
+		var ms macaroon.Slice
+		err := ms.UnmarshalBinary(data)
+		if err != nil {
+			b.Fatalf("cannot unmarshal binary: %v", err)
+		}
+	}
+}
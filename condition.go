@@ -0,0 +1,54 @@
+package macaroon
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// ConditionPolicy holds restrictions on first party caveat conditions.
+// Its Validate method is suitable for passing to
+// Macaroon.SetConditionValidator.
+//
+// The zero value rejects only conditions containing
+// control characters.
+type ConditionPolicy struct {
+	// MaxLen holds the maximum allowed length of
+	// a condition in bytes. If it is zero, there is no limit.
+	MaxLen int
+
+	// AllowControl specifies that ASCII control characters,
+	// including newlines, are allowed in conditions.
+	AllowControl bool
+
+	// IsAllowed, if non-nil, is called for every character
+	// in the condition and reports whether that character
+	// is allowed. When it is set, conditions must also be valid
+	// UTF-8.
+	IsAllowed func(r rune) bool
+}
+
+// Validate checks that the given condition conforms to the policy.
+func (p ConditionPolicy) Validate(cond []byte) error {
+	if p.MaxLen > 0 && len(cond) > p.MaxLen {
+		return fmt.Errorf("condition too long (%d bytes, maximum %d)", len(cond), p.MaxLen)
+	}
+	if !p.AllowControl {
+		for i, b := range cond {
+			if b < 0x20 || b == 0x7f {
+				return fmt.Errorf("control character %q found at offset %d", b, i)
+			}
+		}
+	}
+	if p.IsAllowed == nil {
+		return nil
+	}
+	if !utf8.Valid(cond) {
+		return fmt.Errorf("condition is not valid UTF-8")
+	}
+	for i, r := range string(cond) {
+		if !p.IsAllowed(r) {
+			return fmt.Errorf("disallowed character %q found at offset %d", r, i)
+		}
+	}
+	return nil
+}
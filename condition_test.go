@@ -0,0 +1,126 @@
+package macaroon_test
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+var conditionPolicyTests = []struct {
+	about     string
+	policy    macaroon.ConditionPolicy
+	cond      string
+	expectErr string
+}{{
+	about: "zero policy allows ordinary condition",
+	cond:  "time-before 2018-01-01T00:00:00Z",
+}, {
+	about:     "zero policy rejects newline",
+	cond:      "a\n0014cid injected",
+	expectErr: `control character '\\n' found at offset 1`,
+}, {
+	about:     "zero policy rejects DEL",
+	cond:      "a\x7f",
+	expectErr: `control character '\\x7f' found at offset 1`,
+}, {
+	about: "control characters allowed explicitly",
+	policy: macaroon.ConditionPolicy{
+		AllowControl: true,
+	},
+	cond: "a\nb",
+}, {
+	about: "condition at maximum length",
+	policy: macaroon.ConditionPolicy{
+		MaxLen: 5,
+	},
+	cond: "abcde",
+}, {
+	about: "condition too long",
+	policy: macaroon.ConditionPolicy{
+		MaxLen: 5,
+	},
+	cond:      "abcdef",
+	expectErr: `condition too long \(6 bytes, maximum 5\)`,
+}, {
+	about: "allowed characters",
+	policy: macaroon.ConditionPolicy{
+		IsAllowed: isConditionChar,
+	},
+	cond: "allow read-write",
+}, {
+	about: "disallowed character",
+	policy: macaroon.ConditionPolicy{
+		IsAllowed: isConditionChar,
+	},
+	cond:      "allow read/write",
+	expectErr: `disallowed character '/' found at offset 10`,
+}, {
+	about: "invalid UTF-8 with character set",
+	policy: macaroon.ConditionPolicy{
+		IsAllowed: isConditionChar,
+	},
+	cond:      "allow \xff",
+	expectErr: `condition is not valid UTF-8`,
+}, {
+	about: "invalid UTF-8 without character set",
+	cond:  "allow \xff",
+}}
+
+func isConditionChar(r rune) bool {
+	return r == ' ' || r == '-' || unicode.IsLetter(r)
+}
+
+func TestConditionPolicy(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range conditionPolicyTests {
+		c.Logf("test %d: %s", i, test.about)
+		err := test.policy.Validate([]byte(test.cond))
+		if test.expectErr != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectErr)
+		} else {
+			c.Assert(err, qt.Equals, nil)
+		}
+	}
+}
+
+func TestSetConditionValidator(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.V1)
+	m.SetConditionValidator(macaroon.ConditionPolicy{
+		MaxLen: 20,
+	}.Validate)
+
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	sig := m.Signature()
+
+	err = m.AddFirstPartyCaveat([]byte("a\n0010cid other\n"))
+	c.Assert(err, qt.ErrorMatches, `invalid caveat condition: control character '\\n' found at offset 1`)
+	err = m.AddFirstPartyCaveat([]byte(strings.Repeat("x", 21)))
+	c.Assert(err, qt.ErrorMatches, `invalid caveat condition: condition too long \(21 bytes, maximum 20\)`)
+
+	// Rejected caveats must leave the macaroon untouched.
+	c.Assert(m.Caveats(), qt.HasLen, 1)
+	c.Assert(m.Signature(), qt.DeepEquals, sig)
+
+	// The validator does not apply to third party caveat ids.
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party\ncaveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+
+	// Clones keep the validator.
+	err = m.Clone().AddFirstPartyCaveat([]byte("bad\x00"))
+	c.Assert(err, qt.ErrorMatches, `invalid caveat condition: .*`)
+}
+
+func TestFirstPartyCaveatInvalidForVersion(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m.AddFirstPartyCaveat([]byte("foo\xff"))
+	c.Assert(err, qt.ErrorMatches, `invalid caveat id for v1 macaroon`)
+	c.Assert(m.Caveats(), qt.HasLen, 0)
+}
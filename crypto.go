@@ -10,23 +10,32 @@ import (
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
-func keyedHash(key *[hashLen]byte, text []byte) *[hashLen]byte {
-	h := keyedHasher(key)
+func keyedHash(alg Algorithm, key *[hashLen]byte, text []byte) *[hashLen]byte {
+	h := keyedHasher(alg, key)
 	h.Write([]byte(text))
 	var sum [hashLen]byte
 	hashSum(h, &sum)
 	return &sum
 }
 
-func keyedHasher(key *[hashLen]byte) hash.Hash {
-	return hmac.New(sha256.New, key[:])
+func keyedHasher(alg Algorithm, key *[hashLen]byte) hash.Hash {
+	return hmac.New(algorithms[alg].newHash, key[:])
+}
+
+func keyedHash2(alg Algorithm, key *[keyLen]byte, d1, d2 []byte) *[hashLen]byte {
+	var data [hashLen * 2]byte
+	copy(data[0:], keyedHash(alg, key, d1)[:])
+	copy(data[hashLen:], keyedHash(alg, key, d2)[:])
+	return keyedHash(alg, key, data[:])
 }
 
 var keyGen = []byte("macaroons-key-generator")
 
 // makeKey derives a fixed length key from a variable
 // length key. The keyGen constant is the same
-// as that used in libmacaroons.
+// as that used in libmacaroons. The derivation
+// always uses HMAC-SHA256, whatever the algorithm
+// of the macaroon.
 func makeKey(variableKey []byte) *[keyLen]byte {
 	h := hmac.New(sha256.New, keyGen)
 	h.Write(variableKey)
@@ -46,6 +55,76 @@ func hashSum(h hash.Hash, dest *[hashLen]byte) {
 	}
 }
 
+// DefaultMinRootKeyLen holds the minimum length in bytes of a
+// root key used by New and Verify unless overridden by Minter
+// or Verifier. It is small so that existing short secrets keep
+// working; new root keys should be at least 32 bytes read from a
+// cryptographically secure random source.
+const DefaultMinRootKeyLen = 4
+
+// checkRootKey returns an error if the given root key is shorter
+// than minLen, or DefaultMinRootKeyLen if minLen is zero,
+// unless allowWeak is true. If weak is non-nil, it is called
+// with a description of any low-entropy key that is accepted.
+func checkRootKey(rootKey []byte, minLen int, allowWeak bool, weak func(reason string)) error {
+	if minLen == 0 {
+		minLen = DefaultMinRootKeyLen
+	}
+	if !allowWeak && len(rootKey) < minLen {
+		if len(rootKey) == 0 {
+			return fmt.Errorf("empty root key")
+		}
+		return fmt.Errorf("root key too short (%d bytes, minimum %d)", len(rootKey), minLen)
+	}
+	if weak != nil {
+		if reason := weakRootKeyReason(rootKey); reason != "" {
+			weak(reason)
+		}
+	}
+	return nil
+}
+
+// weakRootKeyReason returns why the given root key appears to
+// have low entropy, or the empty string if it does not.
+func weakRootKeyReason(rootKey []byte) string {
+	if len(rootKey) == 0 {
+		return "empty root key"
+	}
+	for _, b := range rootKey[1:] {
+		if b != rootKey[0] {
+			return ""
+		}
+	}
+	if rootKey[0] == 0 {
+		return "root key is all zero bytes"
+	}
+	return "root key is a single repeated byte"
+}
+
+// checkRootKey checks the given root key according to
+// the settings in mt.
+func (mt *Minter) checkRootKey(rootKey []byte) error {
+	return checkRootKey(rootKey, mt.MinRootKeyLen, mt.AllowWeakRootKey, mt.WeakRootKey)
+}
+
+// checkRootKey checks the given root key according to
+// the settings in v.
+func (v *Verifier) checkRootKey(rootKey []byte) error {
+	return checkRootKey(rootKey, v.MinRootKeyLen, v.AllowWeakRootKey, v.WeakRootKey)
+}
+
+// clearKey overwrites the given key with zeros.
+func clearKey(key *[keyLen]byte) {
+	*key = [keyLen]byte{}
+}
+
+// clearBytes overwrites the given data with zeros.
+func clearBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
 const (
 	keyLen   = 32
 	nonceLen = 24
@@ -54,7 +133,9 @@ const (
 
 func newNonce(r io.Reader) (*[nonceLen]byte, error) {
 	var nonce [nonceLen]byte
-	_, err := r.Read(nonce[:])
+	// Use ReadFull so that a short read can never leave
+	// part of the nonce unset.
+	_, err := io.ReadFull(r, nonce[:])
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate random bytes: %v", err)
 	}
@@ -1,8 +1,10 @@
 package macaroon
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -47,6 +49,29 @@ func TestBadRandom(t *testing.T) {
 	c.Assert(err, qt.ErrorMatches, "^cannot generate random bytes:.*")
 }
 
+// oneByteReader returns data from r one byte at a time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r oneByteReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(buf[0:1])
+}
+
+func TestShortReadNonce(t *testing.T) {
+	c := qt.New(t)
+	data := bytes.Repeat([]byte{0xaa}, nonceLen)
+	nonce, err := newNonce(oneByteReader{bytes.NewReader(data)})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(nonce[:], qt.DeepEquals, data)
+
+	_, err = newNonce(bytes.NewReader(data[1:]))
+	c.Assert(err, qt.ErrorMatches, "cannot generate random bytes: unexpected EOF")
+}
+
 func TestBadCiphertext(t *testing.T) {
 	c := qt.New(t)
 	buf := randomBytes(nonceLen + secretbox.Overhead)
@@ -0,0 +1,89 @@
+package macaroon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+)
+
+// Diff holds the differences between two macaroons
+// with the same id, as returned by Macaroon.Diff.
+type Diff struct {
+	// Removed holds the caveats found in the original
+	// macaroon that are not in the other one.
+	Removed []Caveat
+
+	// Added holds the caveats found in the other macaroon
+	// that are not in the original.
+	Added []Caveat
+
+	// IsAttenuation reports whether the other macaroon
+	// is a valid attenuation of the original: that it holds
+	// all the original caveats in the same order followed
+	// by the added caveats, and that its signature is
+	// the one obtained by adding those caveats to
+	// the original.
+	IsAttenuation bool
+}
+
+// Diff compares m to m1, which must have the same id,
+// and reports the caveats that have been added and removed
+// and whether m1 could have been created by adding caveats
+// to m. This can be useful when debugging why an attenuated
+// macaroon fails to verify.
+//
+// No root key is needed because the signature of m is
+// used as the starting point of the signature chain.
+// Note that a discharge macaroon that has been bound
+// (see Macaroon.Bind) will never be reported as an
+// attenuation of an unbound one.
+func (m *Macaroon) Diff(m1 *Macaroon) (*Diff, error) {
+	if !bytes.Equal(m.id, m1.id) {
+		return nil, fmt.Errorf("macaroon ids differ (%q vs %q)", m.id, m1.id)
+	}
+	var d Diff
+	matched := make([]bool, len(m.caveats))
+	for _, cav1 := range m1.caveats {
+		found := false
+		for i, cav := range m.caveats {
+			if !matched[i] && cav.Equal(cav1) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.Added = append(d.Added, cav1)
+		}
+	}
+	for i, cav := range m.caveats {
+		if !matched[i] {
+			d.Removed = append(d.Removed, cav)
+		}
+	}
+	d.IsAttenuation = m.isAttenuatedBy(m1)
+	return &d, nil
+}
+
+// isAttenuatedBy reports whether m1 holds the caveats of m
+// followed by zero or more extra caveats, with a signature
+// that matches the chain extended from m's signature.
+func (m *Macaroon) isAttenuatedBy(m1 *Macaroon) bool {
+	if m1.alg != m.alg || len(m1.caveats) < len(m.caveats) {
+		return false
+	}
+	for i, cav := range m.caveats {
+		if !cav.Equal(m1.caveats[i]) {
+			return false
+		}
+	}
+	sig := &m.sig
+	for _, cav := range m1.caveats[len(m.caveats):] {
+		if cav.isThirdParty() {
+			sig = keyedHash2(m.alg, sig, cav.VerificationId, cav.Id)
+		} else {
+			sig = keyedHash(m.alg, sig, cav.Id)
+		}
+	}
+	return hmac.Equal(sig[:], m1.sig[:])
+}
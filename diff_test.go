@@ -0,0 +1,82 @@
+package macaroon_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+func TestDiffAttenuation(t *testing.T) {
+	c := qt.New(t)
+	m0 := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.LatestVersion)
+	err := m0.AddFirstPartyCaveat([]byte("first"))
+	c.Assert(err, qt.Equals, nil)
+
+	m1 := m0.Clone()
+	err = m1.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	err = m1.AddFirstPartyCaveat([]byte("second"))
+	c.Assert(err, qt.Equals, nil)
+
+	d, err := m0.Diff(m1)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(d.IsAttenuation, qt.Equals, true)
+	c.Assert(d.Removed, qt.HasLen, 0)
+	c.Assert(d.Added, qt.DeepEquals, m1.Caveats()[1:])
+
+	// The reverse is not an attenuation.
+	d, err = m1.Diff(m0)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(d.IsAttenuation, qt.Equals, false)
+	c.Assert(d.Added, qt.HasLen, 0)
+	c.Assert(d.Removed, qt.DeepEquals, m1.Caveats()[1:])
+
+	// A macaroon is trivially an attenuation of itself.
+	d, err = m0.Diff(m0)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(d, qt.DeepEquals, &macaroon.Diff{
+		IsAttenuation: true,
+	})
+}
+
+func TestDiffNotAttenuation(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m0 := macaroon.MustNew(rootKey, []byte("some id"), "", macaroon.LatestVersion)
+	err := m0.AddFirstPartyCaveat([]byte("a"))
+	c.Assert(err, qt.Equals, nil)
+
+	// Same caveats added in a different order from scratch.
+	m1 := macaroon.MustNew(rootKey, []byte("some id"), "", macaroon.LatestVersion)
+	err = m1.AddFirstPartyCaveat([]byte("b"))
+	c.Assert(err, qt.Equals, nil)
+	err = m1.AddFirstPartyCaveat([]byte("a"))
+	c.Assert(err, qt.Equals, nil)
+	d, err := m0.Diff(m1)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(d.IsAttenuation, qt.Equals, false)
+	c.Assert(d.Removed, qt.HasLen, 0)
+	c.Assert(d.Added, qt.DeepEquals, []macaroon.Caveat{{Id: []byte("b")}})
+
+	// Minted with a different root key.
+	m2 := macaroon.MustNew([]byte("other"), []byte("some id"), "", macaroon.LatestVersion)
+	err = m2.AddFirstPartyCaveat([]byte("a"))
+	c.Assert(err, qt.Equals, nil)
+	err = m2.AddFirstPartyCaveat([]byte("b"))
+	c.Assert(err, qt.Equals, nil)
+	d, err = m0.Diff(m2)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(d.IsAttenuation, qt.Equals, false)
+	c.Assert(d.Added, qt.DeepEquals, []macaroon.Caveat{{Id: []byte("b")}})
+}
+
+func TestDiffDifferentIds(t *testing.T) {
+	c := qt.New(t)
+	m0 := macaroon.MustNew([]byte("secret"), []byte("id0"), "", macaroon.LatestVersion)
+	m1 := macaroon.MustNew([]byte("secret"), []byte("id1"), "", macaroon.LatestVersion)
+	d, err := m0.Diff(m1)
+	c.Assert(err, qt.ErrorMatches, `macaroon ids differ \("id0" vs "id1"\)`)
+	c.Assert(d, qt.IsNil)
+}
@@ -0,0 +1,162 @@
+package macaroon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Draft holds a macaroon that is still being assembled.
+// Unlike a Macaroon, no signature is calculated until
+// Finalize is called, so caveats may be freely added,
+// reordered or removed beforehand. The Caveats field
+// may be manipulated directly.
+type Draft struct {
+	// Id holds the id of the macaroon.
+	Id []byte
+
+	// Location holds the location hint of the macaroon.
+	Location string
+
+	// Version holds the version of the macaroon.
+	Version Version
+
+	// Algorithm holds the algorithm used to sign
+	// the macaroon. See NewWithAlgorithm.
+	Algorithm Algorithm
+
+	// Caveats holds the caveats, in the order that
+	// they will be added to the macaroon.
+	Caveats []DraftCaveat
+}
+
+// DraftCaveat holds a caveat within a Draft.
+type DraftCaveat struct {
+	// Id holds the condition of a first party caveat
+	// or the caveat id of a third party caveat.
+	Id []byte
+
+	// RootKey holds the root key shared with the third
+	// party.
+	RootKey []byte
+
+	// Location holds the location hint of a third
+	// party caveat.
+	Location string
+
+	// ThirdParty records that this is a third party caveat.
+	// It is set by Draft.AddThirdPartyCaveat. A caveat
+	// with a non-empty RootKey is always a third party caveat.
+	ThirdParty bool
+}
+
+// isThirdParty reports whether the caveat must be satisfied
+// by some third party.
+func (cav *DraftCaveat) isThirdParty() bool {
+	return cav.ThirdParty || len(cav.RootKey) > 0
+}
+
+// AddFirstPartyCaveat appends a first party caveat to the draft.
+func (d *Draft) AddFirstPartyCaveat(condition []byte) {
+	d.Caveats = append(d.Caveats, DraftCaveat{
+		Id: condition,
+	})
+}
+
+// AddThirdPartyCaveat appends a third party caveat to the draft.
+// See Macaroon.AddThirdPartyCaveat for a description of the
+// arguments.
+func (d *Draft) AddThirdPartyCaveat(rootKey, caveatId []byte, loc string) {
+	d.Caveats = append(d.Caveats, DraftCaveat{
+		Id:         caveatId,
+		RootKey:    rootKey,
+		Location:   loc,
+		ThirdParty: true,
+	})
+}
+
+// RemoveCaveat removes the caveat at index i, preserving
+// the order of the remaining caveats.
+func (d *Draft) RemoveCaveat(i int) {
+	d.Caveats = append(d.Caveats[:i], d.Caveats[i+1:]...)
+}
+
+// Canonicalize puts the draft's caveats into a canonical form,
+// so that drafts holding the same set of caveats produce macaroons
+// with the same caveats in the same order. Exact duplicate caveats
+// are removed. First party caveats are placed before third party
+// caveats, and each group is sorted by caveat id and location.
+func (d *Draft) Canonicalize() {
+	caveats := d.Caveats[:0]
+	for _, cav := range d.Caveats {
+		if !cav.equalIn(caveats) {
+			caveats = append(caveats, cav)
+		}
+	}
+	d.Caveats = caveats
+	sort.SliceStable(caveats, func(i, j int) bool {
+		c0, c1 := &caveats[i], &caveats[j]
+		if c0.isThirdParty() != c1.isThirdParty() {
+			return !c0.isThirdParty()
+		}
+		if c := bytes.Compare(c0.Id, c1.Id); c != 0 {
+			return c < 0
+		}
+		return c0.Location < c1.Location
+	})
+}
+
+// equalIn reports whether an identical caveat
+// is found in caveats.
+func (cav *DraftCaveat) equalIn(caveats []DraftCaveat) bool {
+	for _, c := range caveats {
+		if bytes.Equal(c.Id, cav.Id) &&
+			bytes.Equal(c.RootKey, cav.RootKey) &&
+			c.Location == cav.Location &&
+			c.isThirdParty() == cav.isThirdParty() {
+			return true
+		}
+	}
+	return false
+}
+
+// Finalize returns a new macaroon minted with the given root key
+// holding the contents of the draft. The draft is not changed,
+// and the returned macaroon does not retain any references to it.
+// The root keys are checked as by New and AddThirdPartyCaveat;
+// use Minter.Finalize to change that.
+func (d *Draft) Finalize(rootKey []byte) (*Macaroon, error) {
+	return defaultMinter.finalize(d, rootKey, rand.Reader)
+}
+
+// Finalize is like Draft.Finalize except that it mints
+// the macaroon using the settings in mt.
+func (mt *Minter) Finalize(d *Draft, rootKey []byte) (*Macaroon, error) {
+	return mt.finalize(d, rootKey, rand.Reader)
+}
+
+// finalize is like Finalize except that it uses the given
+// source of randomness for encrypting third party caveat ids.
+func (mt *Minter) finalize(d *Draft, rootKey []byte, r io.Reader) (*Macaroon, error) {
+	m, err := mt.New(rootKey, d.Id, d.Location, d.Version, d.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	for i, cav := range d.Caveats {
+		if !cav.isThirdParty() {
+			if cav.Location != "" {
+				return nil, fmt.Errorf("caveat %d: location not allowed in first party caveat", i)
+			}
+			if err := m.addCaveat(cav.Id, nil, ""); err != nil {
+				return nil, fmt.Errorf("caveat %d: %v", i, err)
+			}
+			continue
+		}
+		if err := mt.addThirdPartyCaveat(m, cav.RootKey, cav.Id, cav.Location, r); err != nil {
+			return nil, fmt.Errorf("caveat %d: %v", i, err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,162 @@
+package macaroon_test
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+func TestDraftFinalize(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	d := &macaroon.Draft{
+		Id:       []byte("some id"),
+		Location: "a location",
+		Version:  macaroon.LatestVersion,
+	}
+	d.AddFirstPartyCaveat([]byte("removed"))
+	d.AddFirstPartyCaveat([]byte("second"))
+	d.AddFirstPartyCaveat([]byte("first"))
+	d.RemoveCaveat(0)
+	d.Caveats[0], d.Caveats[1] = d.Caveats[1], d.Caveats[0]
+
+	m, err := d.Finalize(rootKey)
+	c.Assert(err, qt.Equals, nil)
+
+	expect := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	err = expect.AddFirstPartyCaveat([]byte("first"))
+	c.Assert(err, qt.Equals, nil)
+	err = expect.AddFirstPartyCaveat([]byte("second"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Equal(expect), qt.Equals, true)
+
+	// The macaroon must not share data with the draft.
+	d.Id[0] = 'X'
+	d.Caveats[0].Id[0] = 'X'
+	c.Assert(string(m.Id()), qt.Equals, "some id")
+	c.Assert(string(m.Caveats()[0].Id), qt.Equals, "first")
+}
+
+func TestDraftFinalizeThirdParty(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	dischargeRootKey := []byte("shared root key")
+	d := &macaroon.Draft{
+		Id:      []byte("some id"),
+		Version: macaroon.LatestVersion,
+	}
+	d.AddThirdPartyCaveat(dischargeRootKey, []byte("3rd party caveat"), "remote.com")
+	d.AddFirstPartyCaveat([]byte("a caveat"))
+	m, err := d.Finalize(rootKey)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Caveats(), qt.HasLen, 2)
+	c.Assert(m.Caveats()[0].Location, qt.Equals, "remote.com")
+
+	dm := macaroon.MustNew(dischargeRootKey, []byte("3rd party caveat"), "", macaroon.LatestVersion)
+	dm.Bind(m.Signature())
+	err = m.Verify(rootKey, func(cond string) error {
+		if cond != "a caveat" {
+			return fmt.Errorf("unexpected condition %q", cond)
+		}
+		return nil
+	}, []*macaroon.Macaroon{dm})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func TestDraftFinalizeError(t *testing.T) {
+	c := qt.New(t)
+	d := &macaroon.Draft{
+		Id:      []byte("some id"),
+		Version: macaroon.LatestVersion,
+		Caveats: []macaroon.DraftCaveat{{
+			Id: []byte("a caveat"),
+		}, {
+			Id:       []byte("bad caveat"),
+			Location: "somewhere",
+		}},
+	}
+	m, err := d.Finalize([]byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `caveat 1: location not allowed in first party caveat`)
+	c.Assert(m, qt.IsNil)
+
+	// A third party caveat with an empty root key must not
+	// be treated as a first party caveat.
+	for _, rootKey := range [][]byte{nil, {}} {
+		d = &macaroon.Draft{
+			Id:      []byte("some id"),
+			Version: macaroon.LatestVersion,
+		}
+		d.AddThirdPartyCaveat(rootKey, []byte("3rd party caveat"), "")
+		m, err = d.Finalize([]byte("secret"))
+		c.Assert(err, qt.ErrorMatches, `caveat 0: empty root key`)
+		c.Assert(m, qt.IsNil)
+	}
+
+	d = &macaroon.Draft{
+		Id:      []byte("some id"),
+		Version: 99,
+	}
+	m, err = d.Finalize([]byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `invalid version v99`)
+	c.Assert(m, qt.IsNil)
+}
+
+func TestDraftCanonicalize(t *testing.T) {
+	c := qt.New(t)
+	d := &macaroon.Draft{
+		Id:      []byte("some id"),
+		Version: macaroon.LatestVersion,
+	}
+	d.AddThirdPartyCaveat([]byte("key"), []byte("tp2"), "loc")
+	d.AddFirstPartyCaveat([]byte("b"))
+	d.AddThirdPartyCaveat([]byte("key"), []byte("tp1"), "loc2")
+	d.AddFirstPartyCaveat([]byte("a"))
+	d.AddThirdPartyCaveat([]byte("key"), []byte("tp1"), "loc1")
+	d.AddFirstPartyCaveat([]byte("b"))
+	d.AddThirdPartyCaveat([]byte("key"), []byte("tp2"), "loc")
+	d.Canonicalize()
+	c.Assert(d.Caveats, qt.DeepEquals, []macaroon.DraftCaveat{{
+		Id: []byte("a"),
+	}, {
+		Id: []byte("b"),
+	}, {
+		Id:         []byte("tp1"),
+		RootKey:    []byte("key"),
+		Location:   "loc1",
+		ThirdParty: true,
+	}, {
+		Id:         []byte("tp1"),
+		RootKey:    []byte("key"),
+		Location:   "loc2",
+		ThirdParty: true,
+	}, {
+		Id:         []byte("tp2"),
+		RootKey:    []byte("key"),
+		Location:   "loc",
+		ThirdParty: true,
+	}})
+}
+
+func TestDraftCanonicalizeDeterministic(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	mint := func(conds ...string) *macaroon.Macaroon {
+		d := &macaroon.Draft{
+			Id:      []byte("some id"),
+			Version: macaroon.LatestVersion,
+		}
+		for _, cond := range conds {
+			d.AddFirstPartyCaveat([]byte(cond))
+		}
+		d.Canonicalize()
+		m, err := d.Finalize(rootKey)
+		c.Assert(err, qt.Equals, nil)
+		return m
+	}
+	m0 := mint("x", "y", "z")
+	m1 := mint("z", "x", "y", "x")
+	c.Assert(m0.Equal(m1), qt.Equals, true)
+}
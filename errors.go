@@ -0,0 +1,137 @@
+package macaroon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiDischargeError is the error returned when verifying
+// a macaroon that has a third party caveat for which several
+// discharge macaroons with the appropriate id were provided,
+// but none of them could be verified.
+type MultiDischargeError struct {
+	// Id holds the id of the third party caveat.
+	Id []byte
+
+	// Failures holds the reason that each candidate discharge
+	// macaroon failed, in the order they were tried.
+	Failures []DischargeFailure
+}
+
+// DischargeFailure describes why a candidate discharge
+// macaroon could not be verified.
+type DischargeFailure struct {
+	// Index holds the index of the discharge macaroon
+	// within the discharges passed to Verify.
+	Index int
+
+	// Err holds the verification error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *MultiDischargeError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("discharge %d: %v", f.Index, f.Err)
+	}
+	return fmt.Sprintf("cannot verify any of %d discharge macaroons for caveat %q: %s", len(e.Failures), e.Id, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors for all the failed candidates.
+func (e *MultiDischargeError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// UnusedDischargeError is the error returned when verification
+// would otherwise succeed but some of the discharge macaroons
+// were not used. See also Verifier.AllowUnusedDischarges.
+type UnusedDischargeError struct {
+	// Ids holds the ids of the unused discharge macaroons,
+	// in the order they were provided.
+	Ids [][]byte
+}
+
+// Error implements the error interface.
+func (e *UnusedDischargeError) Error() string {
+	if len(e.Ids) == 1 {
+		return fmt.Sprintf("discharge macaroon %q was not used", e.Ids[0])
+	}
+	ids := make([]string, len(e.Ids))
+	for i, id := range e.Ids {
+		ids[i] = fmt.Sprintf("%q", id)
+	}
+	return fmt.Sprintf("discharge macaroons %s were not used", strings.Join(ids, ", "))
+}
+
+// OverusedDischargeError is the error returned when a discharge
+// macaroon is required by more than one third party caveat.
+// A discharge macaroon can only discharge a single caveat.
+type OverusedDischargeError struct {
+	// Id holds the id of the discharge macaroon.
+	Id []byte
+}
+
+// Error implements the error interface.
+func (e *OverusedDischargeError) Error() string {
+	return fmt.Sprintf("discharge macaroon %q was used more than once", e.Id)
+}
+
+// FieldTooLongError is the error returned when part of a macaroon
+// is too long to be represented in the macaroon's format.
+// This applies only to V1 macaroons. Large data can
+// be stored elsewhere and referred to from a shorter id,
+// or a later version can be used.
+//
+// It is also returned by Parser when a field exceeds
+// Parser.MaxFieldLen.
+type FieldTooLongError struct {
+	// Field holds the name of the field, as used in
+	// the V1 binary format; for example "cid" for
+	// a caveat id.
+	Field string
+
+	// Size holds the length of the data in bytes.
+	Size int
+
+	// Limit holds the maximum allowed length of the data.
+	Limit int
+}
+
+// Error implements the error interface.
+func (e *FieldTooLongError) Error() string {
+	return fmt.Sprintf("%s field too long (%d bytes, maximum %d)", e.Field, e.Size, e.Limit)
+}
+
+// TooManyCaveatsError is the error returned by Parser when
+// a macaroon has more than Parser.MaxCaveats caveats.
+type TooManyCaveatsError struct {
+	// Limit holds the maximum allowed number of caveats.
+	Limit int
+}
+
+// Error implements the error interface.
+func (e *TooManyCaveatsError) Error() string {
+	return fmt.Sprintf("too many caveats (maximum %d)", e.Limit)
+}
+
+// VerifyLimitError is the error returned when verification
+// is abandoned because one of the limits in Verifier
+// was exceeded.
+type VerifyLimitError struct {
+	// Name holds the name of the limit that was exceeded:
+	// "depth", "caveats" or "discharges".
+	Name string
+
+	// Limit holds the value of the limit.
+	Limit int
+}
+
+// Error implements the error interface.
+func (e *VerifyLimitError) Error() string {
+	return fmt.Sprintf("verification %s limit (%d) exceeded", e.Name, e.Limit)
+}
@@ -1,7 +1,14 @@
 module gopkg.in/macaroon.v2
 
+go 1.20
+
 require (
 	github.com/frankban/quicktest v1.0.0
 	github.com/google/go-cmp v0.2.0
 	golang.org/x/crypto v0.0.0-20180723164146-c126467f60eb
 )
+
+require (
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+)
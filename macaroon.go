@@ -27,17 +27,25 @@ type Macaroon struct {
 	caveats  []Caveat
 	sig      [hashLen]byte
 	version  Version
+	alg      Algorithm
+
+	// validateCondition holds the function set by
+	// SetConditionValidator, if any.
+	validateCondition func(cond []byte) error
 }
 
-// Equal reports whether m has exactly the same content as m1.
+// Equal reports whether m has exactly the same content as m1:
+// the same version, algorithm, location, id, caveats
+// (including their verification ids) and signature.
+// The signatures are compared in constant time.
 func (m *Macaroon) Equal(m1 *Macaroon) bool {
 	if m == m1 || m == nil || m1 == nil {
 		return m == m1
 	}
 	if m.location != m1.location ||
 		!bytes.Equal(m.id, m1.id) ||
-		m.sig != m1.sig ||
 		m.version != m1.version ||
+		m.alg != m1.alg ||
 		len(m.caveats) != len(m1.caveats) {
 		return false
 	}
@@ -46,7 +54,7 @@ func (m *Macaroon) Equal(m1 *Macaroon) bool {
 			return false
 		}
 	}
-	return true
+	return hmac.Equal(m.sig[:], m1.sig[:])
 }
 
 // Caveat holds a first party or third party caveat.
@@ -83,29 +91,100 @@ func (cav *Caveat) isThirdParty() bool {
 
 // New returns a new macaroon with the given root key,
 // identifier, location and version.
+//
+// It returns an error if the root key is empty or shorter
+// than DefaultMinRootKeyLen. Use Minter to change that.
+// For a V1 macaroon, it returns a *FieldTooLongError if
+// the id or location is too long to be encoded.
 func New(rootKey, id []byte, loc string, version Version) (*Macaroon, error) {
+	return NewWithAlgorithm(rootKey, id, loc, version, HMACSHA256)
+}
+
+// NewWithAlgorithm is like New except that the macaroon's
+// signature is calculated with the given algorithm.
+// Algorithms other than HMACSHA256 require a V3 macaroon.
+func NewWithAlgorithm(rootKey, id []byte, loc string, version Version, alg Algorithm) (*Macaroon, error) {
+	return defaultMinter.New(rootKey, id, loc, version, alg)
+}
+
+// Minter holds settings that control how macaroons are minted.
+// The zero value holds the settings used by New, NewWithAlgorithm,
+// Macaroon.AddThirdPartyCaveat and Draft.Finalize.
+type Minter struct {
+	// AllowWeakRootKey allows minting with a root key that
+	// is shorter than MinRootKeyLen, including an empty one.
+	// This can be used by services that must keep minting
+	// macaroons with existing keys; see also
+	// Verifier.AllowWeakRootKey.
+	AllowWeakRootKey bool
+
+	// MinRootKeyLen holds the minimum length in bytes
+	// of a root key. If it is zero, DefaultMinRootKeyLen
+	// is used.
+	MinRootKeyLen int
+
+	// WeakRootKey, if non-nil, is called when a root key
+	// that appears to have low entropy, such as one made
+	// entirely of zero bytes, is used for minting. Such keys
+	// are not rejected, because their length alone does not
+	// show that they are weak.
+	WeakRootKey func(reason string)
+}
+
+var defaultMinter Minter
+
+// New is like NewWithAlgorithm except that it mints
+// the macaroon using the settings in mt.
+func (mt *Minter) New(rootKey, id []byte, loc string, version Version, alg Algorithm) (*Macaroon, error) {
 	var m Macaroon
 	if version < V2 {
 		if !utf8.Valid(id) {
-			return nil, fmt.Errorf("invalid id for %v macaroon", id)
+			return nil, fmt.Errorf("invalid id for %v macaroon", version)
+		}
+		if err := checkPacketV1(fieldNameIdentifier, id); err != nil {
+			return nil, err
+		}
+		if err := checkPacketV1(fieldNameLocation, []byte(loc)); err != nil {
+			return nil, err
 		}
-		// TODO check id length too.
 	}
-	if version < V1 || version > LatestVersion {
+	if version < V1 || version > V3 {
 		return nil, fmt.Errorf("invalid version %v", version)
 	}
-	m.version = version
+	if err := checkAlgorithm(version, alg); err != nil {
+		return nil, err
+	}
+	if err := mt.checkRootKey(rootKey); err != nil {
+		return nil, err
+	}
 	m.init(append([]byte(nil), id...), loc, version)
+	m.alg = alg
 	derivedKey := makeKey(rootKey)
-	m.sig = *keyedHash(derivedKey, m.id)
+	m.sig = *keyedHash(m.alg, derivedKey, m.id)
+	clearKey(derivedKey)
 	return &m, nil
 }
 
+// MustNew is like New except that it panics on error.
+// It is intended for use in tests and examples where the
+// arguments are known to be valid.
+func MustNew(rootKey, id []byte, loc string, version Version) *Macaroon {
+	m, err := New(rootKey, id, loc, version)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 // init initializes the macaroon. It retains a reference to id.
+// Any existing caveats are removed and the algorithm
+// is reset to HMACSHA256.
 func (m *Macaroon) init(id []byte, loc string, vers Version) {
 	m.location = loc
 	m.id = append([]byte(nil), id...)
+	m.caveats = nil
 	m.version = vers
+	m.alg = HMACSHA256
 }
 
 // SetLocation sets the location associated with the macaroon.
@@ -116,20 +195,56 @@ func (m *Macaroon) SetLocation(loc string) {
 }
 
 // Clone returns a copy of the receiving macaroon.
+// The copy does not share any data with m.
 func (m *Macaroon) Clone() *Macaroon {
 	m1 := *m
-	// Ensure that if any caveats are appended to the new
-	// macaroon, it will copy the caveats.
-	m1.caveats = m1.caveats[0:len(m1.caveats):len(m1.caveats)]
+	m1.id = append([]byte(nil), m.id...)
+	if m.caveats != nil {
+		m1.caveats = make([]Caveat, len(m.caveats))
+		for i, cav := range m.caveats {
+			m1.caveats[i] = Caveat{
+				Id:             append([]byte(nil), cav.Id...),
+				VerificationId: append([]byte(nil), cav.VerificationId...),
+				Location:       cav.Location,
+			}
+		}
+	}
 	return &m1
 }
 
+// Wipe makes a best-effort attempt to remove sensitive data from
+// memory by overwriting the id, caveats and signature of m with
+// zeros. After calling Wipe, m holds an empty macaroon and
+// should not be used again.
+//
+// Only data owned by m is zeroed: clones of m and the
+// data passed when adding caveats are not affected. Copies
+// of the data that have been made elsewhere, for example
+// by marshaling the macaroon, are not affected either.
+// Note that a macaroon unmarshaled with UnmarshalBinaryNoCopy
+// does not own its caveat data, so wiping it will zero
+// the data it was unmarshaled from.
+func (m *Macaroon) Wipe() {
+	clearBytes(m.id)
+	for _, cav := range m.caveats {
+		clearBytes(cav.Id)
+		clearBytes(cav.VerificationId)
+	}
+	*m = Macaroon{}
+}
+
 // Location returns the macaroon's location hint. This is
 // not verified as part of the macaroon.
 func (m *Macaroon) Location() string {
 	return m.location
 }
 
+// Algorithm returns the algorithm used to calculate
+// the macaroon's signature.
+func (m *Macaroon) Algorithm() Algorithm {
+	return m.alg
+}
+
 // Id returns the id of the macaroon. This can hold
 // arbitrary information.
 func (m *Macaroon) Id() []byte {
@@ -148,10 +263,30 @@ func (m *Macaroon) Signature() []byte {
 
 // Caveats returns the macaroon's caveats.
 // This method will probably change, and it's important not to change the returned caveat.
+// It does not allocate, so it can be called freely.
+//
+// The caveats are always held in the order that they were added.
+// Because each caveat is included in the signature chain in turn,
+// the order cannot be changed once a caveat has been added;
+// use Draft to assemble caveats in an arbitrary order
+// before signing.
 func (m *Macaroon) Caveats() []Caveat {
 	return m.caveats[0:len(m.caveats):len(m.caveats)]
 }
 
+// HasFirstPartyCaveat reports whether m already holds a first
+// party caveat with the given condition. This can be used
+// to avoid adding duplicate caveats, which make the macaroon
+// larger and cause the condition to be checked more than once.
+func (m *Macaroon) HasFirstPartyCaveat(condition []byte) bool {
+	for _, cav := range m.caveats {
+		if !cav.isThirdParty() && bytes.Equal(cav.Id, condition) {
+			return true
+		}
+	}
+	return false
+}
+
 // appendCaveat appends a caveat without modifying the macaroon's signature.
 func (m *Macaroon) appendCaveat(caveatId, verificationId []byte, loc string) {
 	if len(verificationId) == 0 {
@@ -168,40 +303,128 @@ func (m *Macaroon) appendCaveat(caveatId, verificationId []byte, loc string) {
 }
 
 func (m *Macaroon) addCaveat(caveatId, verificationId []byte, loc string) error {
+	if len(verificationId) == 0 && m.validateCondition != nil {
+		if err := m.validateCondition(caveatId); err != nil {
+			return fmt.Errorf("invalid caveat condition: %v", err)
+		}
+	}
 	if m.version < V2 {
 		if !utf8.Valid(caveatId) {
 			return fmt.Errorf("invalid caveat id for %v macaroon", m.version)
 		}
-		// TODO check caveat length too.
+		if err := checkPacketV1(fieldNameCaveatId, caveatId); err != nil {
+			return err
+		}
+		if len(verificationId) > 0 {
+			if err := checkPacketV1(fieldNameCaveatLocation, []byte(loc)); err != nil {
+				return err
+			}
+		}
 	}
+	// Copy the id so that the macaroon owns all its caveat data.
+	caveatId = append([]byte(nil), caveatId...)
 	m.appendCaveat(caveatId, verificationId, loc)
 	if len(verificationId) == 0 {
-		m.sig = *keyedHash(&m.sig, caveatId)
+		m.sig = *keyedHash(m.alg, &m.sig, caveatId)
 	} else {
-		m.sig = *keyedHash2(&m.sig, verificationId, caveatId)
+		m.sig = *keyedHash2(m.alg, &m.sig, verificationId, caveatId)
 	}
 	return nil
 }
 
-func keyedHash2(key *[keyLen]byte, d1, d2 []byte) *[hashLen]byte {
-	var data [hashLen * 2]byte
-	copy(data[0:], keyedHash(key, d1)[:])
-	copy(data[hashLen:], keyedHash(key, d2)[:])
-	return keyedHash(key, data[:])
-}
-
 // Bind prepares the macaroon for being used to discharge the
 // macaroon with the given signature sig. This must be
 // used before it is used in the discharges argument to Verify.
 func (m *Macaroon) Bind(sig []byte) {
-	m.sig = *bindForRequest(sig, &m.sig)
+	m.sig = *bindForRequest(m.alg, sig, &m.sig)
+}
+
+// BindDischarges returns a slice holding primary followed by copies
+// of the given discharge macaroons bound to it (see Bind), ready to
+// be used as the discharges argument to Verify. This enables a
+// trusted service to bind discharges on behalf of a client that
+// cannot do so itself. The discharges must not already be bound;
+// neither they nor primary are modified.
+//
+// BindDischarges checks that the discharges are structurally valid:
+// there must be exactly one discharge macaroon for each third party
+// caveat in primary or in any of the discharges, and no others.
+// Their signatures cannot be checked without the root keys.
+// In the returned slice each discharge macaroon comes after the
+// macaroon holding its caveat.
+func BindDischarges(primary *Macaroon, discharges []*Macaroon) (Slice, error) {
+	if primary == nil {
+		return nil, fmt.Errorf("nil primary macaroon")
+	}
+	byId := make(map[string]int, len(discharges))
+	for i, dm := range discharges {
+		if dm == nil {
+			return nil, fmt.Errorf("nil discharge macaroon at index %d", i)
+		}
+		if _, ok := byId[string(dm.id)]; ok {
+			return nil, fmt.Errorf("duplicate discharge macaroon id %q", dm.id)
+		}
+		byId[string(dm.id)] = i
+	}
+	used := make([]bool, len(discharges))
+	ms := make(Slice, 1, len(discharges)+1)
+	ms[0] = primary
+	// Note that ms grows as discharges are found, so
+	// their caveats are examined too.
+	for i := 0; i < len(ms); i++ {
+		for _, cav := range ms[i].caveats {
+			if !cav.isThirdParty() {
+				continue
+			}
+			di, ok := byId[string(cav.Id)]
+			if !ok {
+				return nil, fmt.Errorf("cannot find discharge macaroon for caveat %x", cav.Id)
+			}
+			if used[di] {
+				return nil, &OverusedDischargeError{
+					Id: append([]byte(nil), cav.Id...),
+				}
+			}
+			used[di] = true
+			ms = append(ms, discharges[di])
+		}
+	}
+	if len(ms) < len(discharges)+1 {
+		var unused [][]byte
+		for di, dm := range discharges {
+			if !used[di] {
+				unused = append(unused, dm.Id())
+			}
+		}
+		return nil, &UnusedDischargeError{
+			Ids: unused,
+		}
+	}
+	for i, dm := range ms[1:] {
+		dm = dm.Clone()
+		dm.Bind(primary.sig[:])
+		ms[i+1] = dm
+	}
+	return ms, nil
 }
 
 // AddFirstPartyCaveat adds a caveat that will be verified
 // by the target service.
+// It returns an error if the condition is rejected by the
+// validator set with SetConditionValidator, or if it is not
+// valid for the macaroon's version. For a V1 macaroon, a
+// condition that is too long results in a *FieldTooLongError.
 func (m *Macaroon) AddFirstPartyCaveat(condition []byte) error {
-	m.addCaveat(condition, nil, "")
-	return nil
+	return m.addCaveat(condition, nil, "")
+}
+
+// SetConditionValidator sets a function that will be used to
+// validate the condition of every first party caveat subsequently
+// added to m. If the function returns an error, the caveat is not
+// added. The validator is preserved by Clone, but not by
+// marshaling. See ConditionPolicy for a ready-made validator.
+func (m *Macaroon) SetConditionValidator(validate func(cond []byte) error) {
+	m.validateCondition = validate
 }
 
 // AddThirdPartyCaveat adds a third-party caveat to the macaroon,
@@ -210,46 +433,79 @@ func (m *Macaroon) AddFirstPartyCaveat(condition []byte) error {
 // way, either by encrypting it with a key known to the third party
 // or by holding a reference to it stored in the third party's
 // storage.
+//
+// Like New, it returns an error if the root key is too
+// short. Use Minter to change that.
 func (m *Macaroon) AddThirdPartyCaveat(rootKey, caveatId []byte, loc string) error {
 	return m.addThirdPartyCaveatWithRand(rootKey, caveatId, loc, rand.Reader)
 }
 
+// AddThirdPartyCaveat is like Macaroon.AddThirdPartyCaveat
+// except that it checks the root key using the settings in mt.
+func (mt *Minter) AddThirdPartyCaveat(m *Macaroon, rootKey, caveatId []byte, loc string) error {
+	return mt.addThirdPartyCaveat(m, rootKey, caveatId, loc, rand.Reader)
+}
+
 // addThirdPartyCaveatWithRand adds a third-party caveat to the macaroon, using
 // the given source of randomness for encrypting the caveat id.
 func (m *Macaroon) addThirdPartyCaveatWithRand(rootKey, caveatId []byte, loc string, r io.Reader) error {
+	return defaultMinter.addThirdPartyCaveat(m, rootKey, caveatId, loc, r)
+}
+
+func (mt *Minter) addThirdPartyCaveat(m *Macaroon, rootKey, caveatId []byte, loc string, r io.Reader) error {
+	if err := mt.checkRootKey(rootKey); err != nil {
+		return err
+	}
 	derivedKey := makeKey(rootKey)
 	verificationId, err := encrypt(&m.sig, derivedKey, r)
+	clearKey(derivedKey)
 	if err != nil {
 		return err
 	}
-	m.addCaveat(caveatId, verificationId, loc)
-	return nil
+	return m.addCaveat(caveatId, verificationId, loc)
 }
 
 var zeroKey [hashLen]byte
 
 // bindForRequest binds the given macaroon
-// to the given signature of its parent macaroon.
-func bindForRequest(rootSig []byte, dischargeSig *[hashLen]byte) *[hashLen]byte {
+// to the given signature of its parent macaroon,
+// using the algorithm of the discharge macaroon.
+func bindForRequest(alg Algorithm, rootSig []byte, dischargeSig *[hashLen]byte) *[hashLen]byte {
 	if bytes.Equal(rootSig, dischargeSig[:]) {
 		return dischargeSig
 	}
-	return keyedHash2(&zeroKey, rootSig, dischargeSig[:])
+	return keyedHash2(alg, &zeroKey, rootSig, dischargeSig[:])
 }
 
 // Verify verifies that the receiving macaroon is valid.
 // The root key must be the same that the macaroon was originally
 // minted with. The check function is called to verify each
 // first-party caveat - it should return an error if the
-// condition is not met.
+// condition is not met. Caveats are checked in the order
+// they were added, and a caveat added more than once will
+// be checked more than once.
 //
 // The discharge macaroons should be provided in discharges.
+// If more than one discharge macaroon has the id of a third
+// party caveat, each is tried in turn until one is verified
+// successfully; if none are, a *MultiDischargeError is returned.
+// Candidates that fail are not reported as unused.
+// Note that the check function will be called for the
+// caveats of each candidate tried.
+//
+// Verification fails with a *VerifyLimitError if the macaroons
+// are nested too deeply or hold too many caveats;
+// see Verifier for the limits.
+//
+// If a discharge macaroon is needed for more than one caveat,
+// an *OverusedDischargeError is returned; if any discharge
+// macaroons are not needed at all, an *UnusedDischargeError
+// is returned.
 //
 // Verify returns nil if the verification succeeds.
+// It uses the default Verifier settings.
 func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, discharges []*Macaroon) error {
-	var vctx verificationContext
-	vctx.init(rootKey, m, discharges, check)
-	return vctx.verify(m, rootKey)
+	return defaultVerifier.Verify(m, rootKey, check, discharges)
 }
 
 // VerifySignature verifies the signature of the given macaroon with respect
@@ -259,103 +515,291 @@ func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, disch
 // The caller is responsible for checking the returned first party caveat
 // conditions.
 func (m *Macaroon) VerifySignature(rootKey []byte, discharges []*Macaroon) ([]string, error) {
+	return defaultVerifier.VerifySignature(m, rootKey, discharges)
+}
+
+// TraceVerify verifies the signature of the macaroon without checking
+// any of the first party caveats, and returns a slice of Traces holding
+// the operations used when verifying the macaroons.
+//
+// Each element in the returned slice corresponds to the
+// operation for one of the argument macaroons, with m at index 0,
+// and discharges at 1 onwards.
+func (m *Macaroon) TraceVerify(rootKey []byte, discharges []*Macaroon) ([]Trace, error) {
+	return defaultVerifier.TraceVerify(m, rootKey, discharges)
+}
+
+// TraceCheck is like Verify except that it also returns
+// a slice of Traces, as TraceVerify does. Each check of a
+// first party caveat is recorded as a TraceCondition
+// operation, so the traces show exactly where verification
+// of a set of discharge macaroons failed.
+//
+// Unlike the traces returned by TraceVerify, the traces
+// do not hold the root keys or any other secret, so they
+// may be logged. As a result, their Results cannot be
+// calculated.
+func (m *Macaroon) TraceCheck(rootKey []byte, check func(caveat string) error, discharges []*Macaroon) ([]Trace, error) {
+	return defaultVerifier.TraceCheck(m, rootKey, check, discharges)
+}
+
+// Verifier holds settings that control how macaroons are verified.
+// The zero value holds the settings used by Macaroon.Verify,
+// Macaroon.VerifySignature, Macaroon.TraceVerify and
+// Macaroon.TraceCheck.
+type Verifier struct {
+	// AllowWeakRootKey allows verification with a root key
+	// that is shorter than MinRootKeyLen, including an empty
+	// one. This can be used to verify macaroons minted by
+	// older versions of this package, which did not reject
+	// such keys.
+	AllowWeakRootKey bool
+
+	// MinRootKeyLen holds the minimum length in bytes of
+	// the root key. If it is zero, DefaultMinRootKeyLen
+	// is used.
+	MinRootKeyLen int
+
+	// WeakRootKey, if non-nil, is called when the root key
+	// appears to have low entropy, such as one made entirely
+	// of zero bytes. As with Minter.WeakRootKey, such keys
+	// are not rejected.
+	WeakRootKey func(reason string)
+
+	// RejectDuplicateDischarges causes verification to fail
+	// when more than one discharge macaroon has the same id,
+	// rather than trying each one in turn.
+	RejectDuplicateDischarges bool
+
+	// AllowUnusedDischarges allows verification to succeed
+	// even when some of the discharge macaroons were not
+	// needed. This can be useful when clients send
+	// all the discharges they hold.
+	AllowUnusedDischarges bool
+
+	// MaxDepth holds the maximum depth of nested discharge
+	// macaroons. If it is zero, DefaultMaxDepth is used;
+	// if it is negative, there is no limit.
+	MaxDepth int
+
+	// MaxCaveats holds the maximum total number of caveats
+	// that will be examined, including those in discharge
+	// macaroons. If it is zero, DefaultMaxCaveats is used;
+	// if it is negative, there is no limit.
+	MaxCaveats int
+
+	// MaxDischarges holds the maximum number of times that
+	// a discharge macaroon will be verified, including
+	// candidates tried when there are duplicate discharge ids.
+	// If it is zero, DefaultMaxDischarges is used;
+	// if it is negative, there is no limit.
+	MaxDischarges int
+}
+
+// These constants hold the default verification limits.
+// See Verifier for details.
+const (
+	DefaultMaxDepth      = 64
+	DefaultMaxCaveats    = 10000
+	DefaultMaxDischarges = 1000
+)
+
+// limit returns the limit to use given the
+// configured value and the default.
+func limit(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+var defaultVerifier Verifier
+
+// Verify is like Macaroon.Verify except that it verifies m
+// using the settings in v.
+func (v *Verifier) Verify(m *Macaroon, rootKey []byte, check func(caveat string) error, discharges []*Macaroon) error {
+	var vctx verificationContext
+	vctx.init(v, m, discharges, check)
+	return vctx.verify(m, rootKey)
+}
+
+// VerifySignature is like Macaroon.VerifySignature except that
+// it verifies m using the settings in v.
+func (v *Verifier) VerifySignature(m *Macaroon, rootKey []byte, discharges []*Macaroon) ([]string, error) {
 	n := len(m.caveats)
 	for _, dm := range discharges {
 		n += len(dm.caveats)
 	}
-	conds := make([]string, 0, n)
 	var vctx verificationContext
-	vctx.init(rootKey, m, discharges, func(cond string) error {
-		conds = append(conds, cond)
-		return nil
-	})
+	vctx.init(v, m, discharges, nil)
+	vctx.conds = make([]string, 0, n)
 	err := vctx.verify(m, rootKey)
 	if err != nil {
 		return nil, err
 	}
-	return conds, nil
+	return vctx.conds, nil
 }
 
-// TraceVerify verifies the signature of the macaroon without checking
-// any of the first party caveats, and returns a slice of Traces holding
-// the operations used when verifying the macaroons.
-//
-// Each element in the returned slice corresponds to the
-// operation for one of the argument macaroons, with m at index 0,
-// and discharges at 1 onwards.
-func (m *Macaroon) TraceVerify(rootKey []byte, discharges []*Macaroon) ([]Trace, error) {
+// TraceVerify is like Macaroon.TraceVerify except that it
+// verifies m using the settings in v.
+func (v *Verifier) TraceVerify(m *Macaroon, rootKey []byte, discharges []*Macaroon) ([]Trace, error) {
+	var vctx verificationContext
+	vctx.init(v, m, discharges, func(string) error { return nil })
+	vctx.traces = make([]Trace, len(discharges)+1)
+	err := vctx.verify(m, rootKey)
+	return vctx.traces, err
+}
+
+// TraceCheck is like Macaroon.TraceCheck except that it
+// verifies m using the settings in v.
+func (v *Verifier) TraceCheck(m *Macaroon, rootKey []byte, check func(caveat string) error, discharges []*Macaroon) ([]Trace, error) {
 	var vctx verificationContext
-	vctx.init(rootKey, m, discharges, func(string) error { return nil })
+	vctx.init(v, m, discharges, check)
 	vctx.traces = make([]Trace, len(discharges)+1)
+	vctx.traceChecks = true
 	err := vctx.verify(m, rootKey)
 	return vctx.traces, err
 }
 
 type verificationContext struct {
-	used       []bool
+	verifier   *Verifier
+	used       []dischargeState
 	discharges []*Macaroon
 	rootSig    *[hashLen]byte
 	traces     []Trace
-	check      func(caveat string) error
+
+	// traceChecks is set by TraceCheck. The checks of
+	// first party caveats are recorded in traces and,
+	// because the traces may be logged, keys and
+	// signatures are not.
+	traceChecks bool
+
+	// check holds the function used to check first party
+	// caveats. If it is nil, the conditions are collected
+	// in conds instead.
+	check func(caveat string) error
+	conds []string
+
+	// retries holds the number of times that a discharge
+	// macaroon has been tried after another one with
+	// the same id failed.
+	retries int
+
+	// depth, ncaveats and ndischarges track the
+	// work done, to be checked against the verifier's limits.
+	depth       int
+	ncaveats    int
+	ndischarges int
+}
+
+// verificationState holds the parts of a verificationContext
+// that must be restored when a candidate discharge macaroon
+// fails verification.
+type verificationState struct {
+	used   []dischargeState
+	traces []Trace
+	nconds int
 }
 
-func (vctx *verificationContext) init(rootKey []byte, root *Macaroon, discharges []*Macaroon, check func(caveat string) error) {
+// dischargeState records whether a discharge macaroon
+// has been used during verification.
+type dischargeState uint8
+
+const (
+	dischargeUnused dischargeState = iota
+	dischargeUsed
+
+	// dischargeRejected records that the discharge failed
+	// verification when tried as one of several candidates
+	// for a caveat. It may still be used for another caveat.
+	dischargeRejected
+)
+
+func (vctx *verificationContext) init(v *Verifier, root *Macaroon, discharges []*Macaroon, check func(caveat string) error) {
 	*vctx = verificationContext{
+		verifier:   v,
 		discharges: discharges,
-		used:       make([]bool, len(discharges)),
+		used:       make([]dischargeState, len(discharges)),
 		rootSig:    &root.sig,
 		check:      check,
 	}
 }
 
 func (vctx *verificationContext) verify(root *Macaroon, rootKey []byte) error {
+	if err := vctx.verifier.checkRootKey(rootKey); err != nil {
+		return err
+	}
+	if vctx.verifier.RejectDuplicateDischarges {
+		if err := checkDuplicateDischarges(vctx.discharges); err != nil {
+			return err
+		}
+	}
 	vctx.traceRootKey(0, rootKey)
-	vctx.trace(0, TraceMakeKey, rootKey, nil)
+	vctx.trace(0, TraceMakeKey, vctx.traceSecret(rootKey), nil)
 	derivedKey := makeKey(rootKey)
-	if err := vctx.verify0(root, 0, derivedKey); err != nil {
+	err := vctx.verify0(root, 0, derivedKey)
+	clearKey(derivedKey)
+	if err != nil {
 		vctx.trace(0, TraceFail, nil, nil)
 		return err
 	}
-	for i, wasUsed := range vctx.used {
-		if !wasUsed {
+	if vctx.verifier.AllowUnusedDischarges {
+		return nil
+	}
+	var unused [][]byte
+	for i, state := range vctx.used {
+		if state == dischargeUnused {
 			vctx.trace(i+1, TraceFail, nil, nil)
-			return fmt.Errorf("discharge macaroon %q was not used", vctx.discharges[i].Id())
+			unused = append(unused, vctx.discharges[i].Id())
+		}
+	}
+	if len(unused) > 0 {
+		return &UnusedDischargeError{
+			Ids: unused,
 		}
 	}
 	return nil
 }
 
 func (vctx *verificationContext) verify0(m *Macaroon, index int, rootKey *[hashLen]byte) error {
+	vctx.ncaveats += len(m.caveats)
+	if err := vctx.checkLimit("caveats", vctx.ncaveats, vctx.verifier.MaxCaveats, DefaultMaxCaveats); err != nil {
+		return err
+	}
+	if vctx.traces != nil {
+		vctx.traces[index].Algorithm = m.alg
+	}
 	vctx.trace(index, TraceHash, m.id, nil)
-	caveatSig := keyedHash(rootKey, m.id)
+	caveatSig := keyedHash(m.alg, rootKey, m.id)
 	for i, cav := range m.caveats {
 		if cav.isThirdParty() {
 			cavKey, err := decrypt(caveatSig, cav.VerificationId)
 			if err != nil {
 				return fmt.Errorf("failed to decrypt caveat %d signature: %v", i, err)
 			}
-			dm, di, err := vctx.findDischarge(cav.Id)
-			if err != nil {
-				return err
+			err = vctx.verifyDischarge(cav.Id, cavKey)
+			if vctx.traces == nil || vctx.traceChecks {
+				// The key is retained by the trace otherwise.
+				clearKey(cavKey)
 			}
-			vctx.traceRootKey(di+1, cavKey[:])
-			if err := vctx.verify0(dm, di+1, cavKey); err != nil {
-				vctx.trace(di+1, TraceFail, nil, nil)
+			if err != nil {
 				return err
 			}
 			vctx.trace(index, TraceHash, cav.VerificationId, cav.Id)
-			caveatSig = keyedHash2(caveatSig, cav.VerificationId, cav.Id)
+			caveatSig = keyedHash2(m.alg, caveatSig, cav.VerificationId, cav.Id)
 		} else {
 			vctx.trace(index, TraceHash, cav.Id, nil)
-			caveatSig = keyedHash(caveatSig, cav.Id)
-			if err := vctx.check(string(cav.Id)); err != nil {
+			caveatSig = keyedHash(m.alg, caveatSig, cav.Id)
+			if vctx.check == nil {
+				vctx.conds = append(vctx.conds, string(cav.Id))
+			} else if err := vctx.checkCondition(index, cav.Id); err != nil {
 				return err
 			}
 		}
 	}
 	if index > 0 {
-		vctx.trace(index, TraceBind, vctx.rootSig[:], caveatSig[:])
-		caveatSig = bindForRequest(vctx.rootSig[:], caveatSig)
+		vctx.trace(index, TraceBind, vctx.traceSecret(vctx.rootSig[:]), vctx.traceSecret(caveatSig[:]))
+		caveatSig = bindForRequest(m.alg, vctx.rootSig[:], caveatSig)
 	}
 	// TODO perhaps we should actually do this check before doing
 	// all the potentially expensive caveat checks.
@@ -365,21 +809,144 @@ func (vctx *verificationContext) verify0(m *Macaroon, index int, rootKey *[hashL
 	return nil
 }
 
-func (vctx *verificationContext) findDischarge(id []byte) (dm *Macaroon, index int, err error) {
+// verifyDischarge verifies the discharge macaroon for the third
+// party caveat with the given id, using the given root key.
+// When there are several candidates, each one is tried in order.
+func (vctx *verificationContext) verifyDischarge(id []byte, rootKey *[hashLen]byte) error {
+	candidates, err := vctx.findDischarges(id)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 1 {
+		return vctx.verifyDischarge0(candidates[0], rootKey)
+	}
+	var failures []DischargeFailure
+	for i, di := range candidates {
+		if i > 0 {
+			// Bound the total number of retries so that
+			// maliciously nested duplicates cannot cause
+			// exponential work.
+			if vctx.retries >= len(vctx.discharges) {
+				break
+			}
+			vctx.retries++
+		}
+		state := vctx.save()
+		err := vctx.verifyDischarge0(di, rootKey)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*VerifyLimitError); ok {
+			// There's no point in trying other candidates.
+			return err
+		}
+		vctx.restore(state)
+		vctx.used[di] = dischargeRejected
+		vctx.trace(di+1, TraceFail, nil, nil)
+		failures = append(failures, DischargeFailure{
+			Index: di,
+			Err:   err,
+		})
+	}
+	return &MultiDischargeError{
+		Id:       id,
+		Failures: failures,
+	}
+}
+
+func (vctx *verificationContext) verifyDischarge0(di int, rootKey *[hashLen]byte) error {
+	vctx.ndischarges++
+	if err := vctx.checkLimit("discharges", vctx.ndischarges, vctx.verifier.MaxDischarges, DefaultMaxDischarges); err != nil {
+		return err
+	}
+	if err := vctx.checkLimit("depth", vctx.depth+1, vctx.verifier.MaxDepth, DefaultMaxDepth); err != nil {
+		return err
+	}
+	// Don't use a discharge macaroon more than once.
+	// It's important that we mark it as used before
+	// verifying it as it prevents potentially infinite recursion.
+	vctx.used[di] = dischargeUsed
+	vctx.traceRootKey(di+1, rootKey[:])
+	vctx.depth++
+	err := vctx.verify0(vctx.discharges[di], di+1, rootKey)
+	vctx.depth--
+	if err != nil {
+		vctx.trace(di+1, TraceFail, nil, nil)
+		return err
+	}
+	return nil
+}
+
+// checkLimit returns a *VerifyLimitError if n exceeds
+// the limit derived from the given configured
+// and default values.
+func (vctx *verificationContext) checkLimit(name string, n, configured, def int) error {
+	lim := limit(configured, def)
+	if lim >= 0 && n > lim {
+		return &VerifyLimitError{
+			Name:  name,
+			Limit: lim,
+		}
+	}
+	return nil
+}
+
+// findDischarges returns the indexes of all the unused discharge
+// macaroons with the given id.
+func (vctx *verificationContext) findDischarges(id []byte) ([]int, error) {
+	var candidates []int
+	found := false
 	for di, dm := range vctx.discharges {
 		if !bytes.Equal(dm.id, id) {
 			continue
 		}
-		// Don't use a discharge macaroon more than once.
-		// It's important that we do this check here rather than after
-		// verify as it prevents potentially infinite recursion.
-		if vctx.used[di] {
-			return nil, 0, fmt.Errorf("discharge macaroon %q was used more than once", dm.Id())
+		found = true
+		if vctx.used[di] != dischargeUsed {
+			candidates = append(candidates, di)
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+	if found {
+		return nil, &OverusedDischargeError{
+			Id: append([]byte(nil), id...),
+		}
+	}
+	return nil, fmt.Errorf("cannot find discharge macaroon for caveat %x", id)
+}
+
+// save returns the current state of vctx so that
+// it can be restored later.
+func (vctx *verificationContext) save() verificationState {
+	s := verificationState{
+		used:   append([]dischargeState(nil), vctx.used...),
+		nconds: len(vctx.conds),
+	}
+	if vctx.traces != nil {
+		s.traces = append([]Trace(nil), vctx.traces...)
+	}
+	return s
+}
+
+// restore restores the state of vctx saved by save.
+func (vctx *verificationContext) restore(s verificationState) {
+	copy(vctx.used, s.used)
+	copy(vctx.traces, s.traces)
+	vctx.conds = vctx.conds[:s.nconds]
+}
+
+// checkDuplicateDischarges returns an error if any two
+// of the given discharge macaroons have the same id.
+func checkDuplicateDischarges(discharges []*Macaroon) error {
+	ids := make(map[string]bool, len(discharges))
+	for _, dm := range discharges {
+		if ids[string(dm.id)] {
+			return fmt.Errorf("duplicate discharge macaroon id %q", dm.id)
 		}
-		vctx.used[di] = true
-		return dm, di, nil
+		ids[string(dm.id)] = true
 	}
-	return nil, 0, fmt.Errorf("cannot find discharge macaroon for caveat %x", id)
+	return nil
 }
 
 func (vctx *verificationContext) trace(index int, op TraceOpKind, data1, data2 []byte) {
@@ -393,7 +960,35 @@ func (vctx *verificationContext) trace(index int, op TraceOpKind, data1, data2 [
 }
 
 func (vctx *verificationContext) traceRootKey(index int, rootKey []byte) {
-	if vctx.traces != nil {
+	if vctx.traces != nil && !vctx.traceChecks {
+		if rootKey == nil {
+			// A nil RootKey signifies a redacted trace.
+			rootKey = []byte{}
+		}
 		vctx.traces[index].RootKey = rootKey[:]
 	}
 }
+
+// traceSecret returns the given key or signature as it
+// should be recorded in a trace operation.
+func (vctx *verificationContext) traceSecret(data []byte) []byte {
+	if vctx.traceChecks {
+		return nil
+	}
+	return data
+}
+
+// checkCondition checks the first party caveat with the
+// given condition in the macaroon at the given index,
+// recording the result if required.
+func (vctx *verificationContext) checkCondition(index int, cond []byte) error {
+	err := vctx.check(string(cond))
+	if vctx.traceChecks {
+		var result []byte
+		if err != nil {
+			result = []byte(err.Error())
+		}
+		vctx.trace(index, TraceCondition, cond, result)
+	}
+	return err
+}
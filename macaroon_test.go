@@ -1,10 +1,13 @@
 package macaroon_test
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -19,7 +22,7 @@ func never(string) error {
 func TestNoCaveats(t *testing.T) {
 	c := qt.New(t)
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 	c.Assert(m.Location(), qt.Equals, "a location")
 	c.Assert(m.Id(), qt.DeepEquals, []byte("some id"))
 
@@ -30,7 +33,7 @@ func TestNoCaveats(t *testing.T) {
 func TestFirstPartyCaveat(t *testing.T) {
 	c := qt.New(t)
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 
 	caveats := map[string]bool{
 		"a caveat":       true,
@@ -64,14 +67,14 @@ func TestFirstPartyCaveat(t *testing.T) {
 func TestThirdPartyCaveat(t *testing.T) {
 	c := qt.New(t)
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 
 	dischargeRootKey := []byte("shared root key")
 	thirdPartyCaveatId := []byte("3rd party caveat")
 	err := m.AddThirdPartyCaveat(dischargeRootKey, thirdPartyCaveatId, "remote.com")
 	c.Assert(err, qt.IsNil)
 
-	dm := MustNew(dischargeRootKey, thirdPartyCaveatId, "remote location", macaroon.LatestVersion)
+	dm := macaroon.MustNew(dischargeRootKey, thirdPartyCaveatId, "remote location", macaroon.LatestVersion)
 	dm.Bind(m.Signature())
 	err = m.Verify(rootKey, never, []*macaroon.Macaroon{dm})
 	c.Assert(err, qt.IsNil)
@@ -80,7 +83,7 @@ func TestThirdPartyCaveat(t *testing.T) {
 func TestThirdPartyCaveatBadRandom(t *testing.T) {
 	c := qt.New(t)
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 	dischargeRootKey := []byte("shared root key")
 	thirdPartyCaveatId := []byte("3rd party caveat")
 
@@ -91,12 +94,116 @@ func TestThirdPartyCaveatBadRandom(t *testing.T) {
 func TestSetLocation(t *testing.T) {
 	c := qt.New(t)
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 	c.Assert(m.Location(), qt.Equals, "a location")
 	m.SetLocation("another location")
 	c.Assert(m.Location(), qt.Equals, "another location")
 }
 
+func TestHasFirstPartyCaveat(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "", macaroon.LatestVersion)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.HasFirstPartyCaveat([]byte("a caveat")), qt.Equals, true)
+	c.Assert(m.HasFirstPartyCaveat([]byte("another caveat")), qt.Equals, false)
+	c.Assert(m.HasFirstPartyCaveat([]byte("3rd party caveat")), qt.Equals, false)
+}
+
+func TestWipe(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	caveats := m.Caveats()
+	m.Wipe()
+
+	zero := func(data []byte) bool {
+		for _, b := range data {
+			if b != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	for i, cav := range caveats {
+		c.Assert(zero(cav.Id), qt.Equals, true, qt.Commentf("caveat %d", i))
+		c.Assert(zero(cav.VerificationId), qt.Equals, true, qt.Commentf("caveat %d", i))
+	}
+	c.Assert(m.Id(), qt.HasLen, 0)
+	c.Assert(m.Caveats(), qt.HasLen, 0)
+	c.Assert(zero(m.Signature()), qt.Equals, true)
+	c.Assert(m.Location(), qt.Equals, "")
+}
+
+func TestWipeDoesNotAffectCallerData(t *testing.T) {
+	c := qt.New(t)
+	id := []byte("some id")
+	cond := []byte("a caveat")
+	cid := []byte("3rd party caveat")
+	m := macaroon.MustNew([]byte("secret"), id, "", macaroon.LatestVersion)
+	err := m.AddFirstPartyCaveat(cond)
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), cid, "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	m.Wipe()
+	c.Assert(string(id), qt.Equals, "some id")
+	c.Assert(string(cond), qt.Equals, "a caveat")
+	c.Assert(string(cid), qt.Equals, "3rd party caveat")
+}
+
+func TestWipeDoesNotAffectClone(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "", macaroon.LatestVersion)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+
+	m1 := m.Clone()
+	m.Wipe()
+	data1, err := m1.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(data1, qt.DeepEquals, data)
+
+	// Wiping the clone does not affect the original either.
+	m = m1.Clone()
+	m1.Wipe()
+	data1, err = m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(data1, qt.DeepEquals, data)
+}
+
+func TestWipeBoundDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, ms := makeUnboundMacaroons(multilevelThirdPartyCaveatMacaroons)
+	orig := make(macaroon.Slice, len(ms))
+	for i, m := range ms {
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+		orig[i] = new(macaroon.Macaroon)
+		err = orig[i].UnmarshalBinary(data)
+		c.Assert(err, qt.Equals, nil)
+	}
+	bound, err := macaroon.BindDischarges(ms[0], ms[1:])
+	c.Assert(err, qt.Equals, nil)
+	for _, m := range bound[1:] {
+		m.Wipe()
+	}
+	c.Assert(ms.Equal(orig), qt.Equals, true)
+	bound, err = macaroon.BindDischarges(ms[0], ms[1:])
+	c.Assert(err, qt.Equals, nil)
+	err = bound[0].Verify(rootKey, func(string) error { return nil }, bound[1:])
+	c.Assert(err, qt.Equals, nil)
+}
+
 var equalTests = []struct {
 	about  string
 	m1, m2 macaroonSpec
@@ -208,7 +315,7 @@ var equalTests = []struct {
 		caveats: []caveat{{
 			condition: "a",
 			location:  "b",
-			rootKey:   "c",
+			rootKey:   "c-root-key",
 		}},
 	},
 	m2: macaroonSpec{
@@ -217,7 +324,7 @@ var equalTests = []struct {
 		caveats: []caveat{{
 			condition: "a1",
 			location:  "b",
-			rootKey:   "c",
+			rootKey:   "c-root-key",
 		}},
 	},
 	expect: false,
@@ -237,12 +344,46 @@ func TestEqual(t *testing.T) {
 func TestEqualNil(t *testing.T) {
 	c := qt.New(t)
 	var nilm *macaroon.Macaroon
-	var m = MustNew([]byte("k"), []byte("x"), "l", macaroon.LatestVersion)
+	var m = macaroon.MustNew([]byte("root-key"), []byte("x"), "l", macaroon.LatestVersion)
 	c.Assert(nilm.Equal(nilm), qt.Equals, true)
 	c.Assert(nilm.Equal(m), qt.Equals, false)
 	c.Assert(m.Equal(nilm), qt.Equals, false)
 }
 
+func TestEqualVerificationId(t *testing.T) {
+	c := qt.New(t)
+	m0 := macaroon.MustNew([]byte("root-key"), []byte("x"), "l", macaroon.V2)
+	err := m0.AddThirdPartyCaveat([]byte("tp key"), []byte("tp id"), "tp location")
+	c.Assert(err, qt.IsNil)
+	data, err := m0.MarshalJSON()
+	c.Assert(err, qt.IsNil)
+
+	// Change only the verification id; the signature stays the same.
+	var obj map[string]interface{}
+	err = json.Unmarshal(data, &obj)
+	c.Assert(err, qt.IsNil)
+	obj["c"].([]interface{})[0].(map[string]interface{})["v64"] = "AAAA"
+	data, err = json.Marshal(obj)
+	c.Assert(err, qt.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalJSON(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(m1.Signature(), qt.DeepEquals, m0.Signature())
+	c.Assert(m0.Equal(&m1), qt.Equals, false)
+}
+
+func TestSliceEqual(t *testing.T) {
+	c := qt.New(t)
+	m0 := macaroon.MustNew([]byte("root-key"), []byte("x"), "l", macaroon.V2)
+	m1 := macaroon.MustNew([]byte("root-key"), []byte("y"), "l", macaroon.V2)
+	c.Assert(macaroon.Slice{m0, m1}.Equal(macaroon.Slice{m0.Clone(), m1.Clone()}), qt.Equals, true)
+	c.Assert(macaroon.Slice{m0, m1}.Equal(macaroon.Slice{m1, m0}), qt.Equals, false)
+	c.Assert(macaroon.Slice{m0, m1}.Equal(macaroon.Slice{m0}), qt.Equals, false)
+	c.Assert(macaroon.Slice{m0, nil}.Equal(macaroon.Slice{m0, nil}), qt.Equals, true)
+	c.Assert(macaroon.Slice{m0, nil}.Equal(macaroon.Slice{m0, m1}), qt.Equals, false)
+	c.Assert(macaroon.Slice(nil).Equal(macaroon.Slice{}), qt.Equals, true)
+}
+
 type conditionTest struct {
 	conditions map[string]bool
 	expectErr  string
@@ -352,7 +493,7 @@ var verifyTests = []struct {
 		conditions: map[string]bool{
 			"wonderful": true,
 		},
-		expectErr: `condition "splendid" not met`,
+		expectErr: `cannot verify any of 2 discharge macaroons for caveat "bob-is-great": discharge 0: condition "splendid" not met; discharge 1: condition "top of the world" not met`,
 	}, {
 		conditions: map[string]bool{
 			"wonderful":        true,
@@ -361,12 +502,12 @@ var verifyTests = []struct {
 		},
 		expectErr: `discharge macaroon "bob-is-great" was not used`,
 	}, {
+		// The first discharge fails, so the second is tried.
 		conditions: map[string]bool{
 			"wonderful":        true,
 			"splendid":         false,
 			"top of the world": true,
 		},
-		expectErr: `condition "splendid" not met`,
 	}, {
 		conditions: map[string]bool{
 			"wonderful":        true,
@@ -657,7 +798,7 @@ func TestTraceVerify(t *testing.T) {
 func TestTraceVerifyFailure(t *testing.T) {
 	c := qt.New(t)
 	rootKey, macaroons := makeMacaroons([]macaroonSpec{{
-		rootKey: "xxx",
+		rootKey: "root-key",
 		id:      "hello",
 		caveats: []caveat{{
 			condition: "cond1",
@@ -699,6 +840,74 @@ func TestTraceVerifyFailure(t *testing.T) {
 	})
 }
 
+func TestTraceCheck(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(multilevelThirdPartyCaveatMacaroons)
+	var checked []string
+	traces, err := macaroons[0].TraceCheck(rootKey, func(cond string) error {
+		checked = append(checked, cond)
+		return nil
+	}, macaroons[1:])
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(traces, qt.HasLen, len(macaroons))
+	var conds []string
+	for i, tr := range traces {
+		// The traces must not hold any secrets.
+		c.Assert(tr.RootKey, qt.IsNil, qt.Commentf("macaroon %d", i))
+		for _, op := range tr.Ops {
+			switch op.Kind {
+			case macaroon.TraceMakeKey, macaroon.TraceBind:
+				c.Assert(op.Data1, qt.IsNil)
+				c.Assert(op.Data2, qt.IsNil)
+			case macaroon.TraceCondition:
+				c.Assert(op.Data2, qt.IsNil)
+				conds = append(conds, string(op.Data1))
+			}
+		}
+		c.Assert(tr.Results(), qt.DeepEquals, make([][]byte, len(tr.Ops)))
+	}
+	sort.Strings(checked)
+	sort.Strings(conds)
+	c.Assert(conds, qt.DeepEquals, checked)
+}
+
+func TestTraceCheckFailure(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(multilevelThirdPartyCaveatMacaroons)
+	traces, err := macaroons[0].TraceCheck(rootKey, func(cond string) error {
+		if cond == "spiffing" {
+			return fmt.Errorf("not spiffing enough")
+		}
+		return nil
+	}, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `not spiffing enough`)
+	c.Assert(traces, qt.HasLen, len(macaroons))
+	var kinds []macaroon.TraceOpKind
+	for _, op := range traces[3].Ops {
+		kinds = append(kinds, op.Kind)
+	}
+	c.Assert(kinds, qt.DeepEquals, []macaroon.TraceOpKind{
+		macaroon.TraceHash,      // id
+		macaroon.TraceHash,      // spiffing
+		macaroon.TraceCondition, // spiffing
+		macaroon.TraceFail,
+	})
+	c.Assert(traces[3].Ops[2].Data1, qt.DeepEquals, []byte("spiffing"))
+	c.Assert(traces[3].Ops[2].Data2, qt.DeepEquals, []byte("not spiffing enough"))
+}
+
+func TestTraceCheckDuplicateDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(duplicateDischargeMacaroons)
+	traces, err := macaroons[0].TraceCheck(rootKey, func(string) error { return nil }, macaroons[1:])
+	c.Assert(err, qt.Equals, nil)
+	// The rejected candidate is shown as failed.
+	ops := traces[1].Ops
+	c.Assert(ops[len(ops)-1].Kind, qt.Equals, macaroon.TraceFail)
+	ops = traces[2].Ops
+	c.Assert(ops[len(ops)-1].Kind, qt.Equals, macaroon.TraceBind)
+}
+
 func b64str(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
 }
@@ -706,10 +915,10 @@ func b64str(b []byte) string {
 func TestVerifySignature(t *testing.T) {
 	c := qt.New(t)
 	rootKey, macaroons := makeMacaroons([]macaroonSpec{{
-		rootKey: "xxx",
+		rootKey: "root-key",
 		id:      "hello",
 		caveats: []caveat{{
-			rootKey:   "y",
+			rootKey:   "y-root-key",
 			condition: "something",
 			location:  "somewhere",
 		}, {
@@ -718,7 +927,7 @@ func TestVerifySignature(t *testing.T) {
 			condition: "cond2",
 		}},
 	}, {
-		rootKey: "y",
+		rootKey: "y-root-key",
 		id:      "something",
 		caveats: []caveat{{
 			condition: "cond3",
@@ -730,11 +939,381 @@ func TestVerifySignature(t *testing.T) {
 	c.Assert(err, qt.IsNil)
 	c.Assert(conds, qt.DeepEquals, []string{"cond3", "cond4", "cond1", "cond2"})
 
-	conds, err = macaroons[0].VerifySignature(nil, macaroons[1:])
+	v := &macaroon.Verifier{
+		AllowWeakRootKey: true,
+	}
+	conds, err = v.VerifySignature(macaroons[0], nil, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `failed to decrypt caveat 0 signature: decryption failure`)
+	c.Assert(conds, qt.IsNil)
+
+	conds, err = macaroons[0].VerifySignature([]byte("wrong"), macaroons[1:])
 	c.Assert(err, qt.ErrorMatches, `failed to decrypt caveat 0 signature: decryption failure`)
 	c.Assert(conds, qt.IsNil)
 }
 
+func TestWeakRootKey(t *testing.T) {
+	c := qt.New(t)
+	for _, rootKey := range [][]byte{nil, {}} {
+		m, err := macaroon.New(rootKey, []byte("some id"), "", macaroon.LatestVersion)
+		c.Assert(err, qt.ErrorMatches, `empty root key`)
+		c.Assert(m, qt.IsNil)
+	}
+	m, err := macaroon.New([]byte("k"), []byte("some id"), "", macaroon.LatestVersion)
+	c.Assert(err, qt.ErrorMatches, `root key too short \(1 bytes, minimum 4\)`)
+	c.Assert(m, qt.IsNil)
+
+	// Low entropy keys are not rejected.
+	m, err = macaroon.New(make([]byte, 32), []byte("some id"), "", macaroon.LatestVersion)
+	c.Assert(err, qt.Equals, nil)
+	err = m.Verify(make([]byte, 32), never, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	m = macaroon.MustNew([]byte("secret"), []byte("some id"), "", macaroon.LatestVersion)
+	err = m.Verify(nil, never, nil)
+	c.Assert(err, qt.ErrorMatches, `empty root key`)
+	_, err = m.VerifySignature([]byte{1, 2}, nil)
+	c.Assert(err, qt.ErrorMatches, `root key too short \(2 bytes, minimum 4\)`)
+	_, err = m.TraceVerify(nil, nil)
+	c.Assert(err, qt.ErrorMatches, `empty root key`)
+
+	err = m.AddThirdPartyCaveat(nil, []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.ErrorMatches, `empty root key`)
+	c.Assert(m.Caveats(), qt.HasLen, 0)
+}
+
+// weakMinter allows minting with weak root keys.
+var weakMinter = &macaroon.Minter{
+	AllowWeakRootKey: true,
+}
+
+func TestMinterAllowWeakRootKey(t *testing.T) {
+	c := qt.New(t)
+	for _, rootKey := range [][]byte{nil, {}, []byte("k")} {
+		m, err := weakMinter.New(rootKey, []byte("some id"), "", macaroon.V2, macaroon.HMACSHA256)
+		c.Assert(err, qt.Equals, nil)
+		err = weakMinter.AddThirdPartyCaveat(m, rootKey, []byte("3rd party caveat"), "remote.com")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m.Caveats(), qt.HasLen, 1)
+
+		d := macaroon.Draft{
+			Id:      []byte("some id"),
+			Version: macaroon.V2,
+		}
+		d.AddThirdPartyCaveat(rootKey, []byte("3rd party caveat"), "remote.com")
+		_, err = d.Finalize(rootKey)
+		c.Assert(err, qt.ErrorMatches, `empty root key|root key too short .*`)
+		m1, err := weakMinter.Finalize(&d, rootKey)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Caveats(), qt.HasLen, 1)
+
+		v := &macaroon.Verifier{
+			AllowWeakRootKey: true,
+		}
+		dm, err := weakMinter.New(rootKey, []byte("3rd party caveat"), "", macaroon.V2, macaroon.HMACSHA256)
+		c.Assert(err, qt.Equals, nil)
+		dm.Bind(m.Signature())
+		err = v.Verify(m, rootKey, never, []*macaroon.Macaroon{dm})
+		c.Assert(err, qt.Equals, nil)
+	}
+}
+
+func TestMinRootKeyLen(t *testing.T) {
+	c := qt.New(t)
+	mt := &macaroon.Minter{
+		MinRootKeyLen: 16,
+	}
+	_, err := mt.New([]byte("short"), []byte("some id"), "", macaroon.V2, macaroon.HMACSHA256)
+	c.Assert(err, qt.ErrorMatches, `root key too short \(5 bytes, minimum 16\)`)
+	m, err := mt.New([]byte("a long enough key"), []byte("some id"), "", macaroon.V2, macaroon.HMACSHA256)
+	c.Assert(err, qt.Equals, nil)
+	err = mt.AddThirdPartyCaveat(m, []byte("short"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.ErrorMatches, `root key too short \(5 bytes, minimum 16\)`)
+	c.Assert(m.Caveats(), qt.HasLen, 0)
+
+	v := &macaroon.Verifier{
+		MinRootKeyLen: 16,
+	}
+	err = v.Verify(m, []byte("a long enough key"), never, nil)
+	c.Assert(err, qt.Equals, nil)
+	m = macaroon.MustNew([]byte("short"), []byte("some id"), "", macaroon.V2)
+	err = v.Verify(m, []byte("short"), never, nil)
+	c.Assert(err, qt.ErrorMatches, `root key too short \(5 bytes, minimum 16\)`)
+
+	// AllowWeakRootKey overrides the minimum.
+	mt.AllowWeakRootKey = true
+	_, err = mt.New([]byte("short"), []byte("some id"), "", macaroon.V2, macaroon.HMACSHA256)
+	c.Assert(err, qt.Equals, nil)
+	v.AllowWeakRootKey = true
+	err = v.Verify(m, []byte("short"), never, nil)
+	c.Assert(err, qt.Equals, nil)
+}
+
+var weakRootKeyTests = []struct {
+	about        string
+	rootKey      []byte
+	expectReason string
+}{{
+	about:   "good key",
+	rootKey: []byte("secret"),
+}, {
+	about:        "all zero bytes",
+	rootKey:      make([]byte, 32),
+	expectReason: "root key is all zero bytes",
+}, {
+	about:        "repeated byte",
+	rootKey:      []byte("aaaaaaaa"),
+	expectReason: "root key is a single repeated byte",
+}, {
+	about:        "empty",
+	expectReason: "empty root key",
+}}
+
+func TestWeakRootKeyHook(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range weakRootKeyTests {
+		c.Logf("test %d: %s", i, test.about)
+		var reasons []string
+		weak := func(reason string) {
+			reasons = append(reasons, reason)
+		}
+		mt := &macaroon.Minter{
+			AllowWeakRootKey: true,
+			WeakRootKey:      weak,
+		}
+		m, err := mt.New(test.rootKey, []byte("some id"), "", macaroon.V2, macaroon.HMACSHA256)
+		c.Assert(err, qt.Equals, nil)
+		v := &macaroon.Verifier{
+			AllowWeakRootKey: true,
+			WeakRootKey:      weak,
+		}
+		err = v.Verify(m, test.rootKey, never, nil)
+		c.Assert(err, qt.Equals, nil)
+		if test.expectReason == "" {
+			c.Assert(reasons, qt.HasLen, 0)
+		} else {
+			c.Assert(reasons, qt.DeepEquals, []string{test.expectReason, test.expectReason})
+		}
+	}
+}
+
+func TestVerifierAllowWeakRootKey(t *testing.T) {
+	c := qt.New(t)
+	// Simulate a macaroon minted with an empty root key by an
+	// older implementation.
+	data, err := macaroon.Base64Decode([]byte("AgIHc29tZSBpZAAABiA4zwWkN2BvChYkSLVH9ONrZh-brC9j9BbptiO54Dy7fA"))
+	c.Assert(err, qt.Equals, nil)
+	var m macaroon.Macaroon
+	err = m.UnmarshalBinary(data)
+	c.Assert(err, qt.Equals, nil)
+
+	err = m.Verify(nil, never, nil)
+	c.Assert(err, qt.ErrorMatches, `empty root key`)
+
+	v := &macaroon.Verifier{
+		AllowWeakRootKey: true,
+	}
+	err = v.Verify(&m, nil, never, nil)
+	c.Assert(err, qt.Equals, nil)
+	conds, err := v.VerifySignature(&m, nil, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(conds, qt.HasLen, 0)
+	traces, err := v.TraceVerify(&m, nil, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(traces, qt.HasLen, 1)
+}
+
+// duplicateDischargeMacaroons holds a primary macaroon with a
+// third party caveat and two discharges for it, the first of which
+// has been minted with the wrong root key.
+var duplicateDischargeMacaroons = []macaroonSpec{{
+	rootKey: "root-key",
+	id:      "root-id",
+	caveats: []caveat{{
+		condition: "wonderful",
+	}, {
+		condition: "bob-is-great",
+		location:  "bob",
+		rootKey:   "bob-caveat-root-key",
+	}},
+}, {
+	rootKey: "bob-caveat-root-key-wrong",
+	id:      "bob-is-great",
+	caveats: []caveat{{
+		condition: "bogus",
+	}},
+}, {
+	rootKey: "bob-caveat-root-key",
+	id:      "bob-is-great",
+	caveats: []caveat{{
+		condition: "splendid",
+	}},
+}}
+
+func TestVerifyDuplicateDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(duplicateDischargeMacaroons)
+	conds, err := macaroons[0].VerifySignature(rootKey, macaroons[1:])
+	c.Assert(err, qt.Equals, nil)
+	// The condition from the failed discharge is not included.
+	c.Assert(conds, qt.DeepEquals, []string{"wonderful", "splendid"})
+
+	traces, err := macaroons[0].TraceVerify(rootKey, macaroons[1:])
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(traces[1].Ops, qt.DeepEquals, []macaroon.TraceOp{{
+		Kind: macaroon.TraceFail,
+	}})
+	r := traces[2].Results()
+	c.Assert(b64str(r[len(r)-1]), qt.Equals, b64str(macaroons[2].Signature()))
+
+	// With the discharges swapped, the first one succeeds
+	// and the bad one is reported as unused.
+	err = macaroons[0].Verify(rootKey, func(string) error { return nil }, []*macaroon.Macaroon{macaroons[2], macaroons[1]})
+	c.Assert(err, qt.ErrorMatches, `discharge macaroon "bob-is-great" was not used`)
+}
+
+func TestVerifyDuplicateDischargesAllFail(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(duplicateDischargeMacaroons)
+	err := macaroons[0].Verify(rootKey, func(cond string) error {
+		if cond == "splendid" {
+			return fmt.Errorf("not splendid")
+		}
+		return nil
+	}, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `cannot verify any of 2 discharge macaroons for caveat "bob-is-great": discharge 0: signature mismatch after caveat verification; discharge 1: not splendid`)
+	var merr *macaroon.MultiDischargeError
+	c.Assert(errors.As(err, &merr), qt.Equals, true)
+	c.Assert(string(merr.Id), qt.Equals, "bob-is-great")
+	c.Assert(merr.Failures, qt.HasLen, 2)
+	c.Assert(merr.Failures[0].Index, qt.Equals, 0)
+	c.Assert(merr.Failures[0].Err, qt.ErrorMatches, `signature mismatch after caveat verification`)
+	c.Assert(merr.Failures[1].Index, qt.Equals, 1)
+	c.Assert(merr.Failures[1].Err, qt.ErrorMatches, `not splendid`)
+}
+
+func TestVerifierRejectDuplicateDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(duplicateDischargeMacaroons)
+	v := &macaroon.Verifier{
+		RejectDuplicateDischarges: true,
+	}
+	_, err := v.VerifySignature(macaroons[0], rootKey, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `duplicate discharge macaroon id "bob-is-great"`)
+
+	_, err = v.VerifySignature(macaroons[0], rootKey, macaroons[2:])
+	c.Assert(err, qt.Equals, nil)
+}
+
+func TestVerifyNestedDuplicateDischargesBounded(t *testing.T) {
+	c := qt.New(t)
+	// Each discharge requires a further discharge with
+	// the same id, so no combination can succeed. Without
+	// a bound on retries, verification would try every
+	// permutation of the discharges.
+	const n = 20
+	mspecs := []macaroonSpec{{
+		rootKey: "root-key",
+		id:      "root-id",
+		caveats: []caveat{{
+			condition: "bob-is-great",
+			location:  "bob",
+			rootKey:   "bob-caveat-root-key",
+		}},
+	}}
+	for i := 0; i < n; i++ {
+		mspecs = append(mspecs, macaroonSpec{
+			rootKey: "bob-caveat-root-key",
+			id:      "bob-is-great",
+			caveats: []caveat{{
+				condition: "bob-is-great",
+				location:  "bob",
+				rootKey:   "bob-caveat-root-key",
+			}},
+		})
+	}
+	rootKey, macaroons := makeMacaroons(mspecs)
+	_, err := macaroons[0].VerifySignature(rootKey, macaroons[1:])
+	var merr *macaroon.MultiDischargeError
+	c.Assert(errors.As(err, &merr), qt.Equals, true)
+}
+
+var unusedDischargeMacaroons = []macaroonSpec{{
+	rootKey: "root-key",
+	id:      "root-id",
+	caveats: []caveat{{
+		condition: "bob-is-great",
+		location:  "bob",
+		rootKey:   "bob-caveat-root-key",
+	}},
+}, {
+	rootKey: "other-root-key",
+	id:      "unused-1",
+}, {
+	rootKey: "bob-caveat-root-key",
+	id:      "bob-is-great",
+}, {
+	rootKey: "other-root-key",
+	id:      "unused-2",
+}}
+
+func TestUnusedDischargeError(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(unusedDischargeMacaroons)
+	_, err := macaroons[0].VerifySignature(rootKey, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `discharge macaroons "unused-1", "unused-2" were not used`)
+	var uerr *macaroon.UnusedDischargeError
+	c.Assert(errors.As(err, &uerr), qt.Equals, true)
+	c.Assert(uerr.Ids, qt.DeepEquals, [][]byte{[]byte("unused-1"), []byte("unused-2")})
+
+	traces, err := macaroons[0].TraceVerify(rootKey, macaroons[1:])
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(traces[1].Ops, qt.DeepEquals, []macaroon.TraceOp{{
+		Kind: macaroon.TraceFail,
+	}})
+	c.Assert(traces[3].Ops, qt.DeepEquals, []macaroon.TraceOp{{
+		Kind: macaroon.TraceFail,
+	}})
+}
+
+func TestVerifierAllowUnusedDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons(unusedDischargeMacaroons)
+	v := &macaroon.Verifier{
+		AllowUnusedDischarges: true,
+	}
+	err := v.Verify(macaroons[0], rootKey, func(string) error { return nil }, macaroons[1:])
+	c.Assert(err, qt.Equals, nil)
+
+	// Missing discharges are still detected.
+	err = v.Verify(macaroons[0], rootKey, func(string) error { return nil }, macaroons[3:])
+	c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`cannot find discharge macaroon for caveat %x`, "bob-is-great"))
+}
+
+func TestOverusedDischargeError(t *testing.T) {
+	c := qt.New(t)
+	rootKey, macaroons := makeMacaroons([]macaroonSpec{{
+		rootKey: "root-key",
+		id:      "root-id",
+		caveats: []caveat{{
+			condition: "bob-is-great",
+			location:  "bob",
+			rootKey:   "bob-caveat-root-key",
+		}, {
+			condition: "bob-is-great",
+			location:  "bob",
+			rootKey:   "bob-caveat-root-key",
+		}},
+	}, {
+		rootKey: "bob-caveat-root-key",
+		id:      "bob-is-great",
+	}})
+	_, err := macaroons[0].VerifySignature(rootKey, macaroons[1:])
+	c.Assert(err, qt.ErrorMatches, `discharge macaroon "bob-is-great" was used more than once`)
+	var oerr *macaroon.OverusedDischargeError
+	c.Assert(errors.As(err, &oerr), qt.Equals, true)
+	c.Assert(string(oerr.Id), qt.Equals, "bob-is-great")
+}
+
 // TODO(rog) move the following JSON-marshal tests into marshal_test.go.
 
 // jsonTestVersions holds the various possible ways of marshaling a macaroon
@@ -744,6 +1323,99 @@ var jsonTestVersions = []macaroon.Version{
 	macaroon.V2,
 }
 
+// makeUnboundMacaroons is like makeMacaroons except that
+// it does not bind the discharge macaroons.
+func makeUnboundMacaroons(mspecs []macaroonSpec) (rootKey []byte, macaroons macaroon.Slice) {
+	for _, mspec := range mspecs {
+		macaroons = append(macaroons, makeMacaroon(mspec))
+	}
+	return []byte(mspecs[0].rootKey), macaroons
+}
+
+func TestBindDischarges(t *testing.T) {
+	c := qt.New(t)
+	rootKey, ms := makeUnboundMacaroons(multilevelThirdPartyCaveatMacaroons)
+	// Reverse the discharges to check that the
+	// result does not depend on their order.
+	discharges := make([]*macaroon.Macaroon, 0, len(ms)-1)
+	for i := len(ms) - 1; i > 0; i-- {
+		discharges = append(discharges, ms[i])
+	}
+	orig := make([]*macaroon.Macaroon, len(discharges))
+	for i, dm := range discharges {
+		orig[i] = dm.Clone()
+	}
+	bound, err := macaroon.BindDischarges(ms[0], discharges)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(bound, qt.HasLen, len(ms))
+	c.Assert(bound[0], qt.Equals, ms[0])
+	c.Assert(string(bound[1].Id()), qt.Equals, "bob-is-great")
+	c.Assert(string(bound[2].Id()), qt.Equals, "charlie-is-great")
+	err = bound[0].Verify(rootKey, func(string) error { return nil }, bound[1:])
+	c.Assert(err, qt.Equals, nil)
+
+	// The original discharges are unchanged.
+	c.Assert(macaroon.Slice(discharges).Equal(orig), qt.Equals, true)
+}
+
+var bindDischargesErrorTests = []struct {
+	about       string
+	discharges  func(ms macaroon.Slice) []*macaroon.Macaroon
+	expectError string
+}{{
+	about: "missing discharge",
+	discharges: func(ms macaroon.Slice) []*macaroon.Macaroon {
+		return ms[1:4]
+	},
+	expectError: `cannot find discharge macaroon for caveat 63656c696e652d69732d6772656174`,
+}, {
+	about: "unused discharge",
+	discharges: func(ms macaroon.Slice) []*macaroon.Macaroon {
+		return append(ms[1:len(ms):len(ms)], macaroon.MustNew([]byte("root-key"), []byte("extra"), "", macaroon.V2))
+	},
+	expectError: `discharge macaroon "extra" was not used`,
+}, {
+	about: "duplicate discharge",
+	discharges: func(ms macaroon.Slice) []*macaroon.Macaroon {
+		return append(ms[1:len(ms):len(ms)], ms[1].Clone())
+	},
+	expectError: `duplicate discharge macaroon id "bob-is-great"`,
+}, {
+	about: "nil discharge",
+	discharges: func(ms macaroon.Slice) []*macaroon.Macaroon {
+		return append(ms[1:len(ms):len(ms)], nil)
+	},
+	expectError: `nil discharge macaroon at index 5`,
+}}
+
+func TestBindDischargesError(t *testing.T) {
+	c := qt.New(t)
+	_, ms := makeUnboundMacaroons(multilevelThirdPartyCaveatMacaroons)
+	for i, test := range bindDischargesErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		bound, err := macaroon.BindDischarges(ms[0], test.discharges(ms))
+		c.Check(err, qt.ErrorMatches, test.expectError)
+		c.Check(bound, qt.IsNil)
+	}
+	_, err := macaroon.BindDischarges(nil, nil)
+	c.Assert(err, qt.ErrorMatches, `nil primary macaroon`)
+}
+
+func TestBindDischargesOverused(t *testing.T) {
+	c := qt.New(t)
+	// Two discharges that each need the other.
+	m0 := macaroon.MustNew([]byte("root key"), []byte("root id"), "", macaroon.V2)
+	err := m0.AddThirdPartyCaveat([]byte("a key"), []byte("a"), "a-location")
+	c.Assert(err, qt.Equals, nil)
+	m1 := macaroon.MustNew([]byte("a key"), []byte("a"), "", macaroon.V2)
+	err = m1.AddThirdPartyCaveat([]byte("a key"), []byte("a"), "a-location")
+	c.Assert(err, qt.Equals, nil)
+	_, err = macaroon.BindDischarges(m0, []*macaroon.Macaroon{m1})
+	c.Assert(err, qt.ErrorMatches, `discharge macaroon "a" was used more than once`)
+	var oerr *macaroon.OverusedDischargeError
+	c.Assert(errors.As(err, &oerr), qt.Equals, true)
+}
+
 func TestMarshalJSON(t *testing.T) {
 	c := qt.New(t)
 	for _, vers := range jsonTestVersions {
@@ -755,7 +1427,7 @@ func TestMarshalJSON(t *testing.T) {
 
 func testMarshalJSONWithVersion(c *qt.C, vers macaroon.Version) {
 	rootKey := []byte("secret")
-	m0 := MustNew(rootKey, []byte("some id"), "a location", vers)
+	m0 := macaroon.MustNew(rootKey, []byte("some id"), "a location", vers)
 	m0.AddFirstPartyCaveat([]byte("account = 3735928559"))
 	m0JSON, err := json.Marshal(m0)
 	c.Assert(err, qt.IsNil)
@@ -933,7 +1605,7 @@ func TestFirstPartyCaveatWithInvalidUTF8(t *testing.T) {
 	rootKey := []byte("secret")
 	badString := "foo\xff"
 
-	m0 := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m0 := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 	err := m0.AddFirstPartyCaveat([]byte(badString))
 	c.Assert(err, qt.Equals, nil)
 }
@@ -959,6 +1631,57 @@ type macaroonSpec struct {
 	location string
 }
 
+// manyDischargesMacaroons returns the specification of a primary
+// macaroon with n third party caveats, each discharged by a
+// macaroon with a single first party caveat.
+func manyDischargesMacaroons(n int) []macaroonSpec {
+	mspecs := []macaroonSpec{{
+		rootKey: "root-key",
+		id:      "root-id",
+	}}
+	for i := 0; i < n; i++ {
+		rootKey := fmt.Sprintf("discharge-root-key-%d", i)
+		id := fmt.Sprintf("discharge-id-%d", i)
+		mspecs[0].caveats = append(mspecs[0].caveats, caveat{
+			condition: id,
+			location:  "somewhere",
+			rootKey:   rootKey,
+		})
+		mspecs = append(mspecs, macaroonSpec{
+			rootKey: rootKey,
+			id:      id,
+			caveats: []caveat{{
+				condition: "wonderful",
+			}},
+		})
+	}
+	return mspecs
+}
+
+// deepDischargesMacaroons returns the specification of a chain
+// of n discharge macaroons, each holding a third party caveat
+// discharged by the next.
+func deepDischargesMacaroons(n int) []macaroonSpec {
+	mspecs := []macaroonSpec{{
+		rootKey: "root-key",
+		id:      "root-id",
+	}}
+	for i := 0; i < n; i++ {
+		rootKey := fmt.Sprintf("discharge-root-key-%d", i)
+		id := fmt.Sprintf("discharge-id-%d", i)
+		mspecs[i].caveats = append(mspecs[i].caveats, caveat{
+			condition: id,
+			location:  "somewhere",
+			rootKey:   rootKey,
+		})
+		mspecs = append(mspecs, macaroonSpec{
+			rootKey: rootKey,
+			id:      id,
+		})
+	}
+	return mspecs
+}
+
 func makeMacaroons(mspecs []macaroonSpec) (rootKey []byte, macaroons macaroon.Slice) {
 	for _, mspec := range mspecs {
 		macaroons = append(macaroons, makeMacaroon(mspec))
@@ -971,7 +1694,7 @@ func makeMacaroons(mspecs []macaroonSpec) (rootKey []byte, macaroons macaroon.Sl
 }
 
 func makeMacaroon(mspec macaroonSpec) *macaroon.Macaroon {
-	m := MustNew([]byte(mspec.rootKey), []byte(mspec.id), mspec.location, macaroon.LatestVersion)
+	m := macaroon.MustNew([]byte(mspec.rootKey), []byte(mspec.id), mspec.location, macaroon.LatestVersion)
 	for _, cav := range mspec.caveats {
 		if cav.location != "" {
 			err := m.AddThirdPartyCaveat([]byte(cav.rootKey), []byte(cav.condition), cav.location)
@@ -995,6 +1718,7 @@ func assertEqualMacaroons(c *qt.C, m0, m1 *macaroon.Macaroon) {
 	err = json.Unmarshal(m1json, &m1val)
 	c.Assert(err, qt.IsNil)
 	c.Assert(m0val, qt.DeepEquals, m1val)
+	c.Assert(m0.Equal(m1), qt.Equals, true)
 }
 
 func TestBinaryRoundTrip(t *testing.T) {
@@ -1002,7 +1726,7 @@ func TestBinaryRoundTrip(t *testing.T) {
 	// Test the binary marshalling and unmarshalling of a macaroon with
 	// first and third party caveats.
 	rootKey := []byte("secret")
-	m0 := MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
+	m0 := macaroon.MustNew(rootKey, []byte("some id"), "a location", macaroon.LatestVersion)
 	err := m0.AddFirstPartyCaveat([]byte("first caveat"))
 	c.Assert(err, qt.IsNil)
 	err = m0.AddFirstPartyCaveat([]byte("second caveat"))
@@ -1049,7 +1773,8 @@ func TestBinaryFieldBase64Choice(t *testing.T) {
 	c := qt.New(t)
 	for i, test := range binaryFieldBase64ChoiceTests {
 		c.Logf("test %d: %q", i, test.id)
-		m := MustNew([]byte{0}, []byte(test.id), "", macaroon.LatestVersion)
+		m, err := weakMinter.New([]byte{0}, []byte(test.id), "", macaroon.LatestVersion, macaroon.HMACSHA256)
+		c.Assert(err, qt.Equals, nil)
 		data, err := json.Marshal(m)
 		c.Assert(err, qt.Equals, nil)
 		var x struct {
@@ -1071,3 +1796,131 @@ func TestBinaryFieldBase64Choice(t *testing.T) {
 		}
 	}
 }
+
+func TestMustNew(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.LatestVersion)
+	c.Assert(string(m.Id()), qt.Equals, "some id")
+	c.Assert(func() {
+		macaroon.MustNew(nil, []byte("some id"), "a location", macaroon.LatestVersion)
+	}, qt.PanicMatches, `empty root key`)
+}
+
+func TestV1FieldTooLong(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	long := bytes.Repeat([]byte("x"), 70000)
+	var ferr *macaroon.FieldTooLongError
+
+	_, err := macaroon.New(rootKey, long, "", macaroon.V1)
+	c.Assert(err, qt.ErrorMatches, `identifier field too long \(70000 bytes, maximum 65519\)`)
+	c.Assert(errors.As(err, &ferr), qt.Equals, true)
+	c.Assert(*ferr, qt.Equals, macaroon.FieldTooLongError{
+		Field: "identifier",
+		Size:  70000,
+		Limit: 65519,
+	})
+
+	_, err = macaroon.New(rootKey, []byte("some id"), string(long), macaroon.V1)
+	c.Assert(err, qt.ErrorMatches, `location field too long \(70000 bytes, maximum 65521\)`)
+
+	_, err = macaroon.New(rootKey, []byte("\xff"), "", macaroon.V1)
+	c.Assert(err, qt.ErrorMatches, `invalid id for v1 macaroon`)
+
+	m := macaroon.MustNew(rootKey, []byte("some id"), "", macaroon.V1)
+	err = m.AddFirstPartyCaveat(long)
+	c.Assert(err, qt.ErrorMatches, `cid field too long \(70000 bytes, maximum 65526\)`)
+	err = m.AddThirdPartyCaveat([]byte("shared key"), []byte("3rd party"), string(long))
+	c.Assert(err, qt.ErrorMatches, `cl field too long \(70000 bytes, maximum 65527\)`)
+	c.Assert(m.Caveats(), qt.HasLen, 0)
+
+	// The location is not checked by SetLocation, so it
+	// is reported when marshaling.
+	m.SetLocation(string(long))
+	_, err = m.MarshalBinary()
+	c.Assert(errors.As(err, &ferr), qt.Equals, true)
+	c.Assert(ferr.Field, qt.Equals, "location")
+
+	// There are no such limits in V2.
+	m = macaroon.MustNew(rootKey, long, string(long), macaroon.V2)
+	err = m.AddFirstPartyCaveat(long)
+	c.Assert(err, qt.Equals, nil)
+}
+
+var verifierLimitsTests = []struct {
+	about       string
+	verifier    macaroon.Verifier
+	macaroons   []macaroonSpec
+	expectError string
+}{{
+	about:     "depth within limit",
+	verifier:  macaroon.Verifier{MaxDepth: 5},
+	macaroons: deepDischargesMacaroons(5),
+}, {
+	about:       "depth limit exceeded",
+	verifier:    macaroon.Verifier{MaxDepth: 4},
+	macaroons:   deepDischargesMacaroons(5),
+	expectError: `verification depth limit \(4\) exceeded`,
+}, {
+	about:       "default depth limit exceeded",
+	macaroons:   deepDischargesMacaroons(macaroon.DefaultMaxDepth + 1),
+	expectError: `verification depth limit \(64\) exceeded`,
+}, {
+	about:     "no depth limit",
+	verifier:  macaroon.Verifier{MaxDepth: -1},
+	macaroons: deepDischargesMacaroons(macaroon.DefaultMaxDepth + 1),
+}, {
+	about:     "caveats within limit",
+	verifier:  macaroon.Verifier{MaxCaveats: 6},
+	macaroons: manyDischargesMacaroons(3),
+}, {
+	about:       "caveats limit exceeded",
+	verifier:    macaroon.Verifier{MaxCaveats: 5},
+	macaroons:   manyDischargesMacaroons(3),
+	expectError: `verification caveats limit \(5\) exceeded`,
+}, {
+	about:       "primary caveats limit exceeded",
+	verifier:    macaroon.Verifier{MaxCaveats: 2},
+	macaroons:   manyDischargesMacaroons(3),
+	expectError: `verification caveats limit \(2\) exceeded`,
+}, {
+	about:     "discharges within limit",
+	verifier:  macaroon.Verifier{MaxDischarges: 3},
+	macaroons: manyDischargesMacaroons(3),
+}, {
+	about:       "discharges limit exceeded",
+	verifier:    macaroon.Verifier{MaxDischarges: 2},
+	macaroons:   manyDischargesMacaroons(3),
+	expectError: `verification discharges limit \(2\) exceeded`,
+}}
+
+func TestVerifierLimits(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range verifierLimitsTests {
+		c.Logf("test %d: %s", i, test.about)
+		rootKey, macaroons := makeMacaroons(test.macaroons)
+		_, err := test.verifier.VerifySignature(macaroons[0], rootKey, macaroons[1:])
+		if test.expectError == "" {
+			c.Check(err, qt.Equals, nil)
+			continue
+		}
+		c.Check(err, qt.ErrorMatches, test.expectError)
+		var lerr *macaroon.VerifyLimitError
+		c.Check(errors.As(err, &lerr), qt.Equals, true)
+	}
+}
+
+func TestVerifierLimitsWithDuplicateDischarges(t *testing.T) {
+	c := qt.New(t)
+	// The limit error is returned directly rather than
+	// causing the other candidates to be tried.
+	rootKey, macaroons := makeMacaroons(duplicateDischargeMacaroons)
+	v := macaroon.Verifier{
+		MaxCaveats: 2,
+	}
+	_, err := v.VerifySignature(macaroons[0], rootKey, macaroons[1:])
+	c.Assert(err, qt.DeepEquals, &macaroon.VerifyLimitError{
+		Name:  "caveats",
+		Limit: 2,
+	})
+}
@@ -0,0 +1,61 @@
+package macaroon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MarshalJSONAt returns the JSON encoding of v nested inside
+// objects with the given field names, so that macaroons can be
+// embedded in an existing API schema. The value v is usually a
+// *Macaroon or a Slice. For example:
+//
+//	MarshalJSONAt(m, "auth", "token")
+//
+// returns {"auth":{"token":...}} where ... is the JSON
+// encoding of m.
+//
+// To avoid exposing the JSON form of a macaroon to clients,
+// pass the string form of m.MarshalText instead; that form
+// is also accepted by Macaroon.UnmarshalJSON.
+func MarshalJSONAt(v interface{}, path ...string) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		data, err = json.Marshal(map[string]json.RawMessage{
+			path[i]: data,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalJSONAt unmarshals the value found by following the
+// given field names from the JSON object in data into v, which
+// is usually a *Macaroon or a *Slice. It is the inverse of
+// MarshalJSONAt. Other fields in data are ignored.
+func UnmarshalJSONAt(data []byte, v interface{}, path ...string) error {
+	for i, name := range path {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return fmt.Errorf("cannot find JSON field %q: %v", strings.Join(path[:i+1], "."), err)
+		}
+		fieldData, ok := obj[name]
+		if !ok {
+			return fmt.Errorf("JSON field %q not found", strings.Join(path[:i+1], "."))
+		}
+		data = fieldData
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		if len(path) == 0 {
+			return err
+		}
+		return fmt.Errorf("cannot unmarshal JSON field %q: %v", strings.Join(path, "."), err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package macaroon_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+func TestJSONAtRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+
+	data, err := macaroon.MarshalJSONAt(m, "auth", "token")
+	c.Assert(err, qt.Equals, nil)
+	mdata, err := json.Marshal(m)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, `{"auth":{"token":`+string(mdata)+`}}`)
+
+	var m1 macaroon.Macaroon
+	err = macaroon.UnmarshalJSONAt(data, &m1, "auth", "token")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m1.Equal(m), qt.Equals, true)
+
+	// With no path, the value is not wrapped.
+	data, err = macaroon.MarshalJSONAt(m)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(data, qt.DeepEquals, mdata)
+}
+
+func TestJSONAtTextForm(t *testing.T) {
+	c := qt.New(t)
+	ms := macaroon.Slice{
+		macaroon.MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2),
+		macaroon.MustNew([]byte("other secret"), []byte("other id"), "", macaroon.V1),
+	}
+	text, err := ms[0].MarshalText()
+	c.Assert(err, qt.Equals, nil)
+	data, err := macaroon.MarshalJSONAt(string(text), "token")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, `{"token":"`+string(text)+`"}`)
+	var m macaroon.Macaroon
+	err = macaroon.UnmarshalJSONAt(data, &m, "token")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Equal(ms[0]), qt.Equals, true)
+
+	// Other fields are ignored.
+	data = []byte(`{"user":"bob","tokens":{"all":` + mustMarshalJSON(c, ms) + `}}`)
+	var ms1 macaroon.Slice
+	err = macaroon.UnmarshalJSONAt(data, &ms1, "tokens", "all")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms1.Equal(ms), qt.Equals, true)
+}
+
+var unmarshalJSONAtErrorTests = []struct {
+	about       string
+	data        string
+	path        []string
+	expectError string
+}{{
+	about:       "missing field",
+	data:        `{"auth":{}}`,
+	path:        []string{"auth", "token"},
+	expectError: `JSON field "auth.token" not found`,
+}, {
+	about:       "null parent",
+	data:        `{"auth":null}`,
+	path:        []string{"auth", "token"},
+	expectError: `JSON field "auth.token" not found`,
+}, {
+	about:       "parent not an object",
+	data:        `{"auth":"x"}`,
+	path:        []string{"auth", "token"},
+	expectError: `cannot find JSON field "auth.token": json: cannot unmarshal string into Go value of type .*`,
+}, {
+	about:       "invalid macaroon",
+	data:        `{"token":{"i":"x","s":"AAAA"}}`,
+	path:        []string{"token"},
+	expectError: `cannot unmarshal JSON field "token": signature has unexpected length 4`,
+}}
+
+func TestUnmarshalJSONAtError(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range unmarshalJSONAtErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := macaroon.UnmarshalJSONAt([]byte(test.data), &m, test.path...)
+		c.Check(err, qt.ErrorMatches, test.expectError)
+	}
+}
+
+func mustMarshalJSON(c *qt.C, x interface{}) string {
+	data, err := json.Marshal(x)
+	c.Assert(err, qt.Equals, nil)
+	return string(data)
+}
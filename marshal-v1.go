@@ -88,33 +88,49 @@ func (m *Macaroon) initJSONV1(mjson *macaroonJSONV1) error {
 
 // parseBinaryV1 parses the given data in V1 format into the macaroon. The macaroon's
 // internal data structures will retain references to the data. It
-// returns the data after the end of the macaroon.
-func (m *Macaroon) parseBinaryV1(data []byte) ([]byte, error) {
+// returns the data after the end of the macaroon. The limits in
+// parser are checked as the data is parsed.
+func (m *Macaroon) parseBinaryV1(parser *Parser, data []byte) ([]byte, error) {
 	var err error
 
 	loc, err := expectPacketV1(data, fieldNameLocation)
 	if err != nil {
 		return nil, err
 	}
+	if err := parser.checkFieldLen(fieldNameLocation, len(loc.data)); err != nil {
+		return nil, err
+	}
 	data = data[loc.totalLen:]
 	id, err := expectPacketV1(data, fieldNameIdentifier)
 	if err != nil {
 		return nil, err
 	}
+	if err := parser.checkFieldLen(fieldNameIdentifier, len(id.data)); err != nil {
+		return nil, err
+	}
 	data = data[id.totalLen:]
 	m.init(id.data, string(loc.data), V1)
 	var cav Caveat
+	hasLocation := false
 	for {
 		p, err := parsePacketV1(data)
 		if err != nil {
 			return nil, err
 		}
 		data = data[p.totalLen:]
-		switch field := string(p.fieldName); field {
+		field := string(p.fieldName)
+		if field != fieldNameSignature {
+			if err := parser.checkFieldLen(field, len(p.data)); err != nil {
+				return nil, err
+			}
+		}
+		switch field {
 		case fieldNameSignature:
 			// At the end of the caveats we find the signature.
 			if cav.Id != nil {
-				m.caveats = append(m.caveats, cav)
+				if err := m.appendCaveatV1(parser, cav, hasLocation); err != nil {
+					return nil, err
+				}
 			}
 			if len(p.data) != hashLen {
 				return nil, fmt.Errorf("signature has unexpected length %d", len(p.data))
@@ -123,26 +139,49 @@ func (m *Macaroon) parseBinaryV1(data []byte) ([]byte, error) {
 			return data, nil
 		case fieldNameCaveatId:
 			if cav.Id != nil {
-				m.caveats = append(m.caveats, cav)
-				cav = Caveat{}
+				if err := m.appendCaveatV1(parser, cav, hasLocation); err != nil {
+					return nil, err
+				}
+				cav, hasLocation = Caveat{}, false
 			}
 			cav.Id = p.data
 		case fieldNameVerificationId:
+			if cav.Id == nil {
+				return nil, fmt.Errorf("field %q found outside caveat", field)
+			}
 			if cav.VerificationId != nil {
-				return nil, fmt.Errorf("repeated field %q in caveat", fieldNameVerificationId)
+				return nil, fmt.Errorf("repeated field %q in caveat", field)
 			}
 			cav.VerificationId = p.data
 		case fieldNameCaveatLocation:
-			if cav.Location != "" {
-				return nil, fmt.Errorf("repeated field %q in caveat", fieldNameLocation)
+			if cav.Id == nil {
+				return nil, fmt.Errorf("field %q found outside caveat", field)
+			}
+			if hasLocation {
+				return nil, fmt.Errorf("repeated field %q in caveat", field)
 			}
 			cav.Location = string(p.data)
+			hasLocation = true
 		default:
 			return nil, fmt.Errorf("unexpected field %q", field)
 		}
 	}
 }
 
+// appendCaveatV1 appends a caveat parsed from the V1 format,
+// checking that its fields are consistent. The hasLocation
+// parameter reports whether a caveat location field was present.
+func (m *Macaroon) appendCaveatV1(parser *Parser, cav Caveat, hasLocation bool) error {
+	if hasLocation && cav.VerificationId == nil {
+		return fmt.Errorf("location not allowed in first party caveat")
+	}
+	if err := parser.checkCaveatCount(len(m.caveats)); err != nil {
+		return err
+	}
+	m.caveats = append(m.caveats, cav)
+	return nil
+}
+
 func expectPacketV1(data []byte, kind string) (packetV1, error) {
 	p, err := parsePacketV1(data)
 	if err != nil {
@@ -156,35 +195,35 @@ func expectPacketV1(data []byte, kind string) (packetV1, error) {
 
 // appendBinaryV1 appends the binary encoding of m to data.
 func (m *Macaroon) appendBinaryV1(data []byte) ([]byte, error) {
-	var ok bool
-	data, ok = appendPacketV1(data, fieldNameLocation, []byte(m.location))
-	if !ok {
-		return nil, fmt.Errorf("failed to append location to macaroon, packet is too long")
+	var err error
+	data, err = appendPacketV1(data, fieldNameLocation, []byte(m.location))
+	if err != nil {
+		return nil, err
 	}
-	data, ok = appendPacketV1(data, fieldNameIdentifier, m.id)
-	if !ok {
-		return nil, fmt.Errorf("failed to append identifier to macaroon, packet is too long")
+	data, err = appendPacketV1(data, fieldNameIdentifier, m.id)
+	if err != nil {
+		return nil, err
 	}
 	for _, cav := range m.caveats {
-		data, ok = appendPacketV1(data, fieldNameCaveatId, cav.Id)
-		if !ok {
-			return nil, fmt.Errorf("failed to append caveat id to macaroon, packet is too long")
+		data, err = appendPacketV1(data, fieldNameCaveatId, cav.Id)
+		if err != nil {
+			return nil, err
 		}
 		if cav.VerificationId == nil {
 			continue
 		}
-		data, ok = appendPacketV1(data, fieldNameVerificationId, cav.VerificationId)
-		if !ok {
-			return nil, fmt.Errorf("failed to append verification id to macaroon, packet is too long")
+		data, err = appendPacketV1(data, fieldNameVerificationId, cav.VerificationId)
+		if err != nil {
+			return nil, err
 		}
-		data, ok = appendPacketV1(data, fieldNameCaveatLocation, []byte(cav.Location))
-		if !ok {
-			return nil, fmt.Errorf("failed to append verification id to macaroon, packet is too long")
+		data, err = appendPacketV1(data, fieldNameCaveatLocation, []byte(cav.Location))
+		if err != nil {
+			return nil, err
 		}
 	}
-	data, ok = appendPacketV1(data, fieldNameSignature, m.sig[:])
-	if !ok {
-		return nil, fmt.Errorf("failed to append signature to macaroon, packet is too long")
+	data, err = appendPacketV1(data, fieldNameSignature, m.sig[:])
+	if err != nil {
+		return nil, err
 	}
 	return data, nil
 }
@@ -0,0 +1,154 @@
+package macaroon_test
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/macaroon.v2"
+)
+
+// pkt returns a V1 packet holding the given field and data.
+func pkt(field, data string) string {
+	return fmt.Sprintf("%04x%s %s\n", 4+len(field)+1+len(data)+1, field, data)
+}
+
+var (
+	v1Header = pkt("location", "") + pkt("identifier", "")
+	v1Sig    = pkt("signature", "01234567890123456789012345678901")
+)
+
+var unmarshalV1Tests = []struct {
+	about     string
+	data      string
+	expectErr string
+}{{
+	about: "condition that looks like packet framing",
+	data:  v1Header + pkt("cid", "foo\n"+pkt("cid", "injected")+pkt("vid", "")) + v1Sig,
+}, {
+	about:     "declared length stops short of newline",
+	data:      v1Header + "000bcid foo\n" + v1Sig,
+	expectErr: "unmarshal v1: no terminating newline found",
+}, {
+	about:     "declared length runs into following packet",
+	data:      v1Header + "0020cid foo\n" + v1Sig,
+	expectErr: "unmarshal v1: no terminating newline found",
+}, {
+	about:     "verification id without caveat id",
+	data:      v1Header + pkt("vid", "xx") + v1Sig,
+	expectErr: `unmarshal v1: field "vid" found outside caveat`,
+}, {
+	about:     "caveat location without caveat id",
+	data:      v1Header + pkt("cl", "xx") + v1Sig,
+	expectErr: `unmarshal v1: field "cl" found outside caveat`,
+}, {
+	about:     "location in first party caveat",
+	data:      v1Header + pkt("cid", "foo") + pkt("cl", "xx") + v1Sig,
+	expectErr: `unmarshal v1: location not allowed in first party caveat`,
+}, {
+	about:     "repeated caveat location",
+	data:      v1Header + pkt("cid", "foo") + pkt("vid", "xx") + pkt("cl", "") + pkt("cl", "") + v1Sig,
+	expectErr: `unmarshal v1: repeated field "cl" in caveat`,
+}, {
+	about:     "repeated verification id",
+	data:      v1Header + pkt("cid", "foo") + pkt("vid", "xx") + pkt("vid", "xx") + v1Sig,
+	expectErr: `unmarshal v1: repeated field "vid" in caveat`,
+}, {
+	about:     "caveat id with embedded size header",
+	data:      v1Header + "0013cid foo\n000cvid xx\n" + v1Sig,
+	expectErr: "unmarshal v1: no terminating newline found",
+}}
+
+func TestUnmarshalV1(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range unmarshalV1Tests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := m.UnmarshalBinary([]byte(test.data))
+		if test.expectErr != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, qt.Equals, nil)
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(string(data), qt.Equals, test.data)
+	}
+}
+
+func TestUnmarshalV1InjectedCondition(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	cond := "foo\n0015cid injected\n0010vid \n"
+	err := m.AddFirstPartyCaveat([]byte(cond))
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinaryStrict(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m1.Caveats(), qt.DeepEquals, []macaroon.Caveat{{
+		Id: []byte(cond),
+	}})
+}
+
+func TestUnmarshalV1Mutations(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+
+	// Every truncation must be rejected.
+	for i := 0; i < len(data); i++ {
+		var m1 macaroon.Macaroon
+		err := m1.UnmarshalBinary(data[0:i])
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("truncated at %d", i))
+	}
+	// Every single byte change must either be rejected or
+	// result in a macaroon that marshals to exactly the
+	// altered data; that is, no data may be skipped or
+	// reinterpreted.
+	for i := range data {
+		for _, b := range []byte{'0', '9', 'f', ' ', '\n', 'x', 0} {
+			if data[i] == b {
+				continue
+			}
+			mutated := append([]byte(nil), data...)
+			mutated[i] = b
+			var m1 macaroon.Macaroon
+			if err := m1.UnmarshalBinaryStrict(mutated); err != nil {
+				continue
+			}
+			data1, err := m1.MarshalBinary()
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(data1), qt.Equals, string(mutated), qt.Commentf("byte %d set to %q", i, b))
+		}
+	}
+}
+
+func TestUnmarshalBinaryStrict(t *testing.T) {
+	c := qt.New(t)
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalBinaryStrict(data)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+
+		data = append(data, "garbage"...)
+		err = m1.UnmarshalBinaryStrict(data)
+		c.Assert(err, qt.ErrorMatches, `7 bytes of unexpected data after macaroon`)
+
+		// The non-strict form ignores trailing data.
+		err = m1.UnmarshalBinary(data)
+		c.Assert(err, qt.Equals, nil)
+	}
+}
@@ -8,7 +8,10 @@ import (
 )
 
 // macaroonJSONV2 defines the V2 JSON format for macaroons.
+// The V3 format is the same except that it always
+// includes the algorithm.
 type macaroonJSONV2 struct {
+	Algorithm    string         `json:"alg,omitempty"`
 	Caveats      []caveatJSONV2 `json:"c,omitempty"`
 	Location     string         `json:"l,omitempty"`
 	Identifier   string         `json:"i,omitempty"`
@@ -31,6 +34,9 @@ func (m *Macaroon) marshalJSONV2() ([]byte, error) {
 		Location: m.location,
 		Caveats:  make([]caveatJSONV2, len(m.caveats)),
 	}
+	if m.version == V3 {
+		mjson.Algorithm = m.alg.String()
+	}
 	putJSONBinaryField(m.id, &mjson.Identifier, &mjson.Identifier64)
 	putJSONBinaryField(m.sig[:], &mjson.Signature, &mjson.Signature64)
 	for i, cav := range m.caveats {
@@ -56,6 +62,14 @@ func (m *Macaroon) initJSONV2(mjson *macaroonJSONV2) error {
 		return fmt.Errorf("invalid identifier: %v", err)
 	}
 	m.init(id, mjson.Location, V2)
+	if mjson.Algorithm != "" {
+		alg, err := algorithmByName(mjson.Algorithm)
+		if err != nil {
+			return err
+		}
+		m.version = V3
+		m.alg = alg
+	}
 	sig, err := jsonBinaryField(mjson.Signature, mjson.Signature64)
 	if err != nil {
 		return fmt.Errorf("invalid signature: %v", err)
@@ -136,21 +150,49 @@ func jsonBinaryField(s, sb64 string) ([]byte, error) {
 // signature
 //
 // See also https://github.com/rescrv/libmacaroons/blob/master/doc/format.txt
+//
+// The v3 binary format is the same except that the
+// version is followed by a single byte holding the
+// algorithm.
 
 // parseBinaryV2 parses the given data in V2 format into the macaroon. The macaroon's
 // internal data structures will retain references to the data. It
 // returns the data after the end of the macaroon.
-func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
+func (m *Macaroon) parseBinaryV2(parser *Parser, data []byte) ([]byte, error) {
 	// The version has already been checked, so
 	// skip it.
-	data = data[1:]
+	return m.parseBodyV2(parser, data[1:], V2, HMACSHA256)
+}
 
-	data, section, err := parseSectionV2(data)
+// parseBinaryV3 is like parseBinaryV2 but parses the V3 format.
+func (m *Macaroon) parseBinaryV3(parser *Parser, data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("no algorithm found")
+	}
+	alg := Algorithm(data[1])
+	if !alg.valid() {
+		return nil, fmt.Errorf("unknown algorithm %v", alg)
+	}
+	return m.parseBodyV2(parser, data[2:], V3, alg)
+}
+
+// parseBodyV2 parses the part of a V2 or V3 macaroon
+// that follows the header, checking the limits in parser
+// as it goes.
+func (m *Macaroon) parseBodyV2(parser *Parser, data []byte, vers Version, alg Algorithm) ([]byte, error) {
+	// The sections of a valid macaroon hold at most one of
+	// each field type, so this buffer can usually be reused
+	// for all of them.
+	var buf [4]packetV2
+	data, section, err := parseSectionV2(buf[:0], data)
 	if err != nil {
 		return nil, err
 	}
 	var loc string
 	if len(section) > 0 && section[0].fieldType == fieldLocation {
+		if err := parser.checkFieldLen(fieldNameLocation, len(section[0].data)); err != nil {
+			return nil, err
+		}
 		loc = string(section[0].data)
 		section = section[1:]
 	}
@@ -158,9 +200,13 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("invalid macaroon header")
 	}
 	id := section[0].data
-	m.init(id, loc, V2)
+	if err := parser.checkFieldLen(fieldNameIdentifier, len(id)); err != nil {
+		return nil, err
+	}
+	m.init(id, loc, vers)
+	m.alg = alg
 	for {
-		rest, section, err := parseSectionV2(data)
+		rest, section, err := parseSectionV2(buf[:0], data)
 		if err != nil {
 			return nil, err
 		}
@@ -168,8 +214,14 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 		if len(section) == 0 {
 			break
 		}
+		if err := parser.checkCaveatCount(len(m.caveats)); err != nil {
+			return nil, err
+		}
 		var cav Caveat
 		if len(section) > 0 && section[0].fieldType == fieldLocation {
+			if err := parser.checkFieldLen(fieldNameCaveatLocation, len(section[0].data)); err != nil {
+				return nil, err
+			}
 			cav.Location = string(section[0].data)
 			section = section[1:]
 		}
@@ -177,6 +229,9 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 			return nil, fmt.Errorf("no identifier in caveat")
 		}
 		cav.Id = section[0].data
+		if err := parser.checkFieldLen(fieldNameCaveatId, len(cav.Id)); err != nil {
+			return nil, err
+		}
 		section = section[1:]
 		if len(section) == 0 {
 			// First party caveat.
@@ -193,6 +248,9 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 			return nil, fmt.Errorf("invalid field found in caveat")
 		}
 		cav.VerificationId = section[0].data
+		if err := parser.checkFieldLen(fieldNameVerificationId, len(cav.VerificationId)); err != nil {
+			return nil, err
+		}
 		m.caveats = append(m.caveats, cav)
 	}
 	data, sig, err := parsePacketV2(data)
@@ -214,6 +272,20 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 func (m *Macaroon) appendBinaryV2(data []byte) []byte {
 	// Version byte.
 	data = append(data, 2)
+	return m.appendBodyV2(data)
+}
+
+// appendBinaryV3 appends the binary-encoded macaroon
+// in v3 format to data.
+func (m *Macaroon) appendBinaryV3(data []byte) []byte {
+	// Version and algorithm bytes.
+	data = append(data, 3, byte(m.alg))
+	return m.appendBodyV2(data)
+}
+
+// appendBodyV2 appends the part of a V2 or V3 macaroon
+// that follows the header.
+func (m *Macaroon) appendBodyV2(data []byte) []byte {
 	if len(m.location) > 0 {
 		data = appendPacketV2(data, packetV2{
 			fieldType: fieldLocation,
@@ -1,6 +1,7 @@
 package macaroon
 
 import (
+	"encoding/base32"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,8 @@ import (
 // size of any part of the macaroon may not exceed
 // approximately 64K. In version 2,
 // all field may be arbitrary binary blobs.
+// Version 3 is the same as version 2 except that
+// it also records the signature algorithm (see Algorithm).
 type Version uint16
 
 const (
@@ -21,7 +24,14 @@ const (
 	// V2 specifies version 2 macaroons.
 	V2 Version = 2
 
-	// LatestVersion holds the latest supported version.
+	// V3 specifies version 3 macaroons. This version is
+	// specific to this package and is not understood
+	// by other macaroon implementations.
+	V3 Version = 3
+
+	// LatestVersion holds the latest version that is
+	// supported by other macaroon implementations.
+	// V3 must be requested explicitly.
 	LatestVersion = V2
 )
 
@@ -43,7 +53,7 @@ func (m *Macaroon) MarshalJSON() ([]byte, error) {
 	switch m.version {
 	case V1:
 		return m.marshalJSONV1()
-	case V2:
+	case V2, V3:
 		return m.marshalJSONV2()
 	default:
 		return nil, fmt.Errorf("unknown version %v", m.version)
@@ -51,7 +61,7 @@ func (m *Macaroon) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON implements json.Unmarshaller by unmarshaling
-// the given macaroon in JSON format. It accepts both V1 and V2
+// the given macaroon in JSON format. It accepts V1, V2 and V3
 // forms encoded forms, and also a base64-encoded JSON string
 // containing the binary-marshaled macaroon.
 //
@@ -97,7 +107,6 @@ func (m *Macaroon) UnmarshalJSON(data []byte) error {
 		if err := m.initJSONV2((*macaroonJSONV2)(both.MacaroonJSONV2)); err != nil {
 			return err
 		}
-		m.version = V2
 	default:
 		return fmt.Errorf("invalid JSON macaroon encoding")
 	}
@@ -105,38 +114,193 @@ func (m *Macaroon) UnmarshalJSON(data []byte) error {
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
-// It accepts both V1 and V2 binary encodings.
+// It accepts V1, V2 and V3 binary encodings.
+// Any data after the end of the macaroon is ignored;
+// use ParseBinary to obtain it or UnmarshalBinaryStrict
+// to reject it.
 func (m *Macaroon) UnmarshalBinary(data []byte) error {
 	// Copy the data to avoid retaining references to it
 	// in the internal data structures.
 	data = append([]byte(nil), data...)
-	_, err := m.parseBinary(data)
+	_, err := m.parseBinary(&defaultParser, data)
+	return err
+}
+
+// UnmarshalBinaryNoCopy is like UnmarshalBinary except that it
+// does not copy data, which avoids an allocation for each
+// macaroon. The caveats of m will refer to data, so data must
+// not be modified while m is in use, and modifying the caveat
+// data (for example by calling Wipe) will modify data.
+func (m *Macaroon) UnmarshalBinaryNoCopy(data []byte) error {
+	_, err := m.parseBinary(&defaultParser, data)
 	return err
 }
 
+// ParseBinary parses a macaroon in any known binary format
+// from the start of data and returns it along with any data
+// remaining after the end of the macaroon, which is
+// a subslice of data. This allows a macaroon to be
+// followed by other data in the same stream.
+//
+// The returned macaroon does not retain references to data.
+// It uses the default Parser settings.
+func ParseBinary(data []byte) (*Macaroon, []byte, error) {
+	return defaultParser.ParseBinary(data)
+}
+
+// Parser holds settings that control how binary-encoded
+// macaroons are parsed. The zero value imposes no limits
+// other than those of the encoding itself, and is used by
+// ParseBinary and Slice.UnmarshalBinary.
+//
+// The V2 and V3 encodings allow fields of any size, so
+// a service parsing macaroons from untrusted sources
+// may wish to impose limits.
+type Parser struct {
+	// MaxFieldLen holds the maximum allowed length in bytes of
+	// the id, location and any caveat field of a macaroon.
+	// A longer field results in a *FieldTooLongError.
+	// If it is zero, there is no limit.
+	MaxFieldLen int
+
+	// MaxCaveats holds the maximum allowed number of
+	// caveats in a macaroon. More caveats result in
+	// a *TooManyCaveatsError. If it is zero, there is no limit.
+	MaxCaveats int
+}
+
+var defaultParser Parser
+
+// ParseBinary is like the ParseBinary function except
+// that it checks the macaroon against the limits in p.
+// The limits are checked as the macaroon is parsed,
+// so parsing stops at the first field or caveat that
+// exceeds them, and before any data is copied.
+func (p *Parser) ParseBinary(data []byte) (*Macaroon, []byte, error) {
+	var m Macaroon
+	rest, err := m.parseBinary(p, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Copy only the macaroon's own fields so that it
+	// doesn't hold onto data or anything that follows it.
+	m.copyFields(len(data) - len(rest))
+	return &m, rest, nil
+}
+
+// copyFields makes the id and caveats of m refer to a single
+// new buffer instead of the data that m was parsed from,
+// which was size bytes long.
+func (m *Macaroon) copyFields(size int) {
+	buf := make([]byte, 0, size)
+	copyField := func(data []byte) []byte {
+		if data == nil {
+			return nil
+		}
+		buf = append(buf, data...)
+		return buf[len(buf)-len(data) : len(buf) : len(buf)]
+	}
+	m.id = copyField(m.id)
+	for i := range m.caveats {
+		cav := &m.caveats[i]
+		cav.Id = copyField(cav.Id)
+		cav.VerificationId = copyField(cav.VerificationId)
+	}
+}
+
+// ParseSlice parses a sequence of binary-encoded macaroons,
+// as produced by Slice.MarshalBinary, checking each one
+// against the limits in p. The returned macaroons do not
+// retain references to data.
+func (p *Parser) ParseSlice(data []byte) (Slice, error) {
+	// Unlike ParseBinary, all the data is consumed, so
+	// copy it all at once and parse each macaroon only once.
+	data = append([]byte(nil), data...)
+	var s Slice
+	for len(data) > 0 {
+		var m Macaroon
+		rest, err := m.parseBinary(p, data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal macaroon: %w", err)
+		}
+		s = append(s, &m)
+		data = rest
+	}
+	return s, nil
+}
+
+// checkCaveatCount checks that a caveat can be added
+// to a macaroon that already has n caveats.
+func (p *Parser) checkCaveatCount(n int) error {
+	if p.MaxCaveats > 0 && n >= p.MaxCaveats {
+		return &TooManyCaveatsError{
+			Limit: p.MaxCaveats,
+		}
+	}
+	return nil
+}
+
+// checkFieldLen checks that a field of the given
+// size is no longer than p.MaxFieldLen.
+func (p *Parser) checkFieldLen(field string, size int) error {
+	if p.MaxFieldLen > 0 && size > p.MaxFieldLen {
+		return &FieldTooLongError{
+			Field: field,
+			Size:  size,
+			Limit: p.MaxFieldLen,
+		}
+	}
+	return nil
+}
+
+// UnmarshalBinaryStrict is like UnmarshalBinary except that
+// it returns an error if there is any data following
+// the end of the macaroon.
+func (m *Macaroon) UnmarshalBinaryStrict(data []byte) error {
+	data = append([]byte(nil), data...)
+	rest, err := m.parseBinary(&defaultParser, data)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%d bytes of unexpected data after macaroon", len(rest))
+	}
+	return nil
+}
+
 // parseBinary parses the macaroon in binary format
 // from the given data and returns where the parsed data ends.
 //
-// It retains references to data.
-func (m *Macaroon) parseBinary(data []byte) ([]byte, error) {
+// It retains references to data. The limits in p are
+// checked as the data is parsed.
+func (m *Macaroon) parseBinary(p *Parser, data []byte) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("empty macaroon data")
 	}
 	v := data[0]
 	if v == 2 {
 		// Version 2 binary format.
-		data, err := m.parseBinaryV2(data)
+		data, err := m.parseBinaryV2(p, data)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal v2: %v", err)
+			return nil, fmt.Errorf("unmarshal v2: %w", err)
 		}
 		m.version = V2
 		return data, nil
 	}
+	if v == 3 {
+		// Version 3 binary format.
+		data, err := m.parseBinaryV3(p, data)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal v3: %w", err)
+		}
+		m.version = V3
+		return data, nil
+	}
 	if isASCIIHex(v) {
 		// It's a hex digit - version 1 binary format
-		data, err := m.parseBinaryV1(data)
+		data, err := m.parseBinaryV1(p, data)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal v1: %v", err)
+			return nil, fmt.Errorf("unmarshal v1: %w", err)
 		}
 		m.version = V1
 		return data, nil
@@ -160,6 +324,8 @@ func (m *Macaroon) appendBinary(data []byte) ([]byte, error) {
 		return m.appendBinaryV1(data)
 	case V2:
 		return m.appendBinaryV2(data), nil
+	case V3:
+		return m.appendBinaryV3(data), nil
 	default:
 		return nil, fmt.Errorf("bad macaroon version %v", m.version)
 	}
@@ -170,6 +336,22 @@ func (m *Macaroon) appendBinary(data []byte) ([]byte, error) {
 // are discharges for its third party caveats.
 type Slice []*Macaroon
 
+// Equal reports whether s and s1 hold the same number of
+// macaroons and each macaroon in s is equal (see Macaroon.Equal)
+// to the macaroon at the same index in s1. A nil slice
+// is equal to an empty one.
+func (s Slice) Equal(s1 Slice) bool {
+	if len(s) != len(s1) {
+		return false
+	}
+	for i, m := range s {
+		if !m.Equal(s1[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // MarshalBinary implements encoding.BinaryMarshaler.
 func (s Slice) MarshalBinary() ([]byte, error) {
 	var data []byte
@@ -187,19 +369,86 @@ func (s Slice) MarshalBinary() ([]byte, error) {
 // It accepts all known binary encodings for the data - all the
 // embedded macaroons need not be encoded in the same format.
 func (s *Slice) UnmarshalBinary(data []byte) error {
-	// Prevent the internal data structures from holding onto the
-	// slice by copying it first.
-	data = append([]byte(nil), data...)
-	*s = (*s)[:0]
-	for len(data) > 0 {
-		var m Macaroon
-		rest, err := m.parseBinary(data)
+	ms, err := defaultParser.ParseSlice(data)
+	if err != nil {
+		return err
+	}
+	*s = append((*s)[:0], ms...)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler by wrapping
+// the binary encoding of the slice (see MarshalBinary) in
+// URL-safe, unpadded base64.
+func (s Slice) MarshalText() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// Like Base64Decode, it accepts both standard and URL-safe
+// encodings, both padded and unpadded.
+func (s *Slice) UnmarshalText(text []byte) error {
+	data, err := Base64Decode(text)
+	if err != nil {
+		return fmt.Errorf("cannot decode base64 macaroons: %v", err)
+	}
+	return s.UnmarshalBinary(data)
+}
+
+// MarshalJSON implements json.Marshaler by marshaling
+// each macaroon in the JSON format determined by its own
+// version, so a slice holding both V1 and V2 macaroons
+// retains the format of each.
+func (s Slice) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	ms := make([]json.RawMessage, len(s))
+	for i, m := range s {
+		if m == nil {
+			return nil, fmt.Errorf("cannot marshal macaroon %d: nil macaroon", i)
+		}
+		data, err := m.MarshalJSON()
 		if err != nil {
-			return fmt.Errorf("cannot unmarshal macaroon: %v", err)
+			return nil, fmt.Errorf("cannot marshal macaroon %d: %v", i, err)
 		}
-		*s = append(*s, &m)
-		data = rest
+		ms[i] = data
 	}
+	return json.Marshal(ms)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Each element
+// of the array may be in any of the forms accepted by
+// Macaroon.UnmarshalJSON, and the version of each macaroon
+// will reflect the form it was unmarshaled from. As with
+// encoding/json, null sets s to nil. Unlike the default
+// decoding of a slice, null elements are not allowed, because
+// a nil macaroon in a Slice is never valid.
+func (s *Slice) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return err
+	}
+	ms := make(Slice, len(elems))
+	for i, elem := range elems {
+		if string(elem) == "null" {
+			return fmt.Errorf("cannot unmarshal macaroon %d: null macaroon", i)
+		}
+		var m Macaroon
+		if err := m.UnmarshalJSON(elem); err != nil {
+			return fmt.Errorf("cannot unmarshal macaroon %d: %v", i, err)
+		}
+		ms[i] = &m
+	}
+	*s = ms
 	return nil
 }
 
@@ -237,3 +486,143 @@ func Base64Decode(data []byte) ([]byte, error) {
 	}
 	return nil, err
 }
+
+// encodeBase64 returns data encoded as URL-safe,
+// unpadded base64.
+func encodeBase64(data []byte) []byte {
+	buf := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(buf, data)
+	return buf
+}
+
+// MarshalBase64 returns the binary encoding of the macaroon
+// (see MarshalBinary) wrapped in URL-safe, unpadded base64.
+// For a V1 macaroon, this is the same form produced by
+// the libmacaroons serialize function.
+func (m *Macaroon) MarshalBase64() (string, error) {
+	text, err := m.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// ParseBase64 parses a macaroon from the base64-wrapped
+// binary form produced by MarshalBase64 or by libmacaroons.
+// Like Base64Decode, it accepts both standard and URL-safe
+// encodings, both padded and unpadded.
+func ParseBase64(s string) (*Macaroon, error) {
+	var m Macaroon
+	if err := m.UnmarshalText([]byte(s)); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MarshalText implements encoding.TextMarshaler by returning
+// the binary encoding of the macaroon in the URL-safe,
+// unpadded base64 form also returned by MarshalBase64.
+// This allows a macaroon to be used directly as a flag
+// value or in text-based configuration formats.
+func (m *Macaroon) MarshalText() ([]byte, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBase64(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// It accepts the same forms as ParseBase64.
+func (m *Macaroon) UnmarshalText(text []byte) error {
+	data, err := Base64Decode(text)
+	if err != nil {
+		return fmt.Errorf("cannot decode base64 macaroon: %v", err)
+	}
+	return m.UnmarshalBinary(data)
+}
+
+// MarshalBase32 returns the binary encoding of the macaroon
+// (see MarshalBinary) wrapped in unpadded upper-case base32
+// as defined by RFC 4648. This form is suitable for tokens
+// that need to be read out or typed in by hand.
+func (m *Macaroon) MarshalBase32() (string, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(data), nil
+}
+
+// ParseBase32 parses a macaroon from the base32-wrapped
+// binary form produced by MarshalBase32. It is tolerant
+// of transcription: letters may be in either case,
+// white space and hyphens are ignored, and trailing
+// padding is allowed.
+func ParseBase32(s string) (*Macaroon, error) {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == ' ', b == '\t', b == '\r', b == '\n', b == '-':
+		case 'a' <= b && b <= 'z':
+			buf = append(buf, b-'a'+'A')
+		default:
+			buf = append(buf, b)
+		}
+	}
+	for len(buf) > 0 && buf[len(buf)-1] == '=' {
+		buf = buf[:len(buf)-1]
+	}
+	codec := base32.StdEncoding.WithPadding(base32.NoPadding)
+	data := make([]byte, codec.DecodedLen(len(buf)))
+	n, err := codec.Decode(data, buf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode base32 macaroon: %v", err)
+	}
+	return parseBinaryText(data[:n])
+}
+
+// MarshalBase45 returns the binary encoding of the macaroon
+// (see MarshalBinary) wrapped in base45 as defined by RFC 9285.
+// Base45 uses only characters from the QR code alphanumeric
+// set, so it encodes more compactly in QR codes than base64.
+func (m *Macaroon) MarshalBase45() (string, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base45Encode(data), nil
+}
+
+// ParseBase45 parses a macaroon from the base45-wrapped
+// binary form produced by MarshalBase45. Lower-case letters
+// are accepted in place of upper-case ones and tabs and
+// line breaks are ignored. Note that the space character
+// is part of the base45 alphabet, so it is not ignored.
+func ParseBase45(s string) (*Macaroon, error) {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '\t', b == '\r', b == '\n':
+		case 'a' <= b && b <= 'z':
+			buf = append(buf, b-'a'+'A')
+		default:
+			buf = append(buf, b)
+		}
+	}
+	data, err := base45Decode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode base45 macaroon: %v", err)
+	}
+	return parseBinaryText(data)
+}
+
+// parseBinaryText parses a macaroon from binary data
+// decoded from one of its text forms.
+func parseBinaryText(data []byte) (*Macaroon, error) {
+	var m Macaroon
+	if err := m.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -1,7 +1,12 @@
 package macaroon_test
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -21,7 +26,7 @@ func TestMarshalUnmarshalMacaroonV2(t *testing.T) {
 
 func testMarshalUnmarshalWithVersion(c *qt.C, vers macaroon.Version) {
 	rootKey := []byte("secret")
-	m := MustNew(rootKey, []byte("some id"), "a location", vers)
+	m := macaroon.MustNew(rootKey, []byte("some id"), "a location", vers)
 
 	// Adding the third party caveat before the first party caveat
 	// tests a former bug where the caveat wasn't zeroed
@@ -87,7 +92,7 @@ func TestBinaryJSONRoundTripV2(t *testing.T) {
 }
 
 func testBinaryJSONRoundTrip(c *qt.C, vers macaroon.Version) {
-	m1 := MustNew([]byte("rootkey"), []byte("some id"), "a location", vers)
+	m1 := macaroon.MustNew([]byte("rootkey"), []byte("some id"), "a location", vers)
 	err := m1.AddFirstPartyCaveat([]byte("a caveat"))
 	c.Assert(err, qt.Equals, nil)
 	err = m1.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
@@ -121,8 +126,8 @@ func TestMarshalUnmarshalSliceV2(t *testing.T) {
 
 func testMarshalUnmarshalSliceWithVersion(c *qt.C, vers macaroon.Version) {
 	rootKey := []byte("secret")
-	m1 := MustNew(rootKey, []byte("some id"), "a location", vers)
-	m2 := MustNew(rootKey, []byte("some other id"), "another location", vers)
+	m1 := macaroon.MustNew(rootKey, []byte("some id"), "a location", vers)
+	m2 := macaroon.MustNew(rootKey, []byte("some other id"), "another location", vers)
 
 	err := m1.AddFirstPartyCaveat([]byte("a caveat"))
 	c.Assert(err, qt.Equals, nil)
@@ -173,8 +178,8 @@ func TestSliceRoundTripV2(t *testing.T) {
 
 func testSliceRoundTripWithVersion(c *qt.C, vers macaroon.Version) {
 	rootKey := []byte("secret")
-	m1 := MustNew(rootKey, []byte("some id"), "a location", vers)
-	m2 := MustNew(rootKey, []byte("some other id"), "another location", vers)
+	m1 := macaroon.MustNew(rootKey, []byte("some id"), "a location", vers)
+	m2 := macaroon.MustNew(rootKey, []byte("some other id"), "another location", vers)
 
 	err := m1.AddFirstPartyCaveat([]byte("a caveat"))
 	c.Assert(err, qt.Equals, nil)
@@ -196,6 +201,104 @@ func testSliceRoundTripWithVersion(c *qt.C, vers macaroon.Version) {
 	c.Assert(b, qt.DeepEquals, marshaledMacs)
 }
 
+func TestSliceJSONMixedVersions(t *testing.T) {
+	c := qt.New(t)
+	rootKey := []byte("secret")
+	m1 := macaroon.MustNew(rootKey, []byte("v1 id"), "a location", macaroon.V1)
+	m2 := macaroon.MustNew(rootKey, []byte("v2 id"), "another location", macaroon.V2)
+	m3 := macaroon.MustNew(rootKey, []byte("binary id"), "", macaroon.V2)
+	b64, err := m3.MarshalBase64()
+	c.Assert(err, qt.Equals, nil)
+
+	data1, err := m1.MarshalJSON()
+	c.Assert(err, qt.Equals, nil)
+	data2, err := m2.MarshalJSON()
+	c.Assert(err, qt.Equals, nil)
+	data := "[" + string(data1) + ", " + string(data2) + `, "` + b64 + `"]`
+
+	var ms macaroon.Slice
+	err = json.Unmarshal([]byte(data), &ms)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 3)
+	c.Assert(ms[0].Equal(m1), qt.Equals, true)
+	c.Assert(ms[0].Version(), qt.Equals, macaroon.V1)
+	c.Assert(ms[1].Equal(m2), qt.Equals, true)
+	c.Assert(ms[1].Version(), qt.Equals, macaroon.V2)
+	c.Assert(ms[2].Equal(m3), qt.Equals, true)
+	c.Assert(ms[2].Version(), qt.Equals, macaroon.V2)
+
+	// Check that each macaroon is marshaled in the
+	// format it was unmarshaled from.
+	remarshaled, err := json.Marshal(ms)
+	c.Assert(err, qt.Equals, nil)
+	var objs []map[string]interface{}
+	err = json.Unmarshal(remarshaled, &objs)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(objs, qt.HasLen, 3)
+	c.Assert(objs[0]["identifier"], qt.Equals, "v1 id")
+	c.Assert(objs[1]["i"], qt.Equals, "v2 id")
+	c.Assert(objs[2]["i"], qt.Equals, "binary id")
+}
+
+func TestSliceJSONNull(t *testing.T) {
+	c := qt.New(t)
+	var ms macaroon.Slice
+	data, err := json.Marshal(ms)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, "null")
+
+	err = json.Unmarshal([]byte("null"), &ms)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.IsNil)
+
+	// Unmarshaling null resets an existing slice.
+	ms = macaroon.Slice{macaroon.MustNew([]byte("secret"), []byte("id"), "", macaroon.V2)}
+	err = json.Unmarshal([]byte("null"), &ms)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.IsNil)
+}
+
+var sliceUnmarshalJSONErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	// The default decoding of a slice would produce
+	// a nil macaroon here.
+	about:       "null element",
+	data:        `[{"i": "x", "s64": "` + strings.Repeat("A", 43) + `"}, null]`,
+	expectError: `cannot unmarshal macaroon 1: null macaroon`,
+}, {
+	about:       "ambiguous element",
+	data:        `[{"i": "x", "identifier": "x"}]`,
+	expectError: `cannot unmarshal macaroon 0: cannot determine macaroon encoding version`,
+}, {
+	about:       "bad base64 element",
+	data:        `["!!"]`,
+	expectError: `cannot unmarshal macaroon 0: illegal base64 data at input byte 0`,
+}, {
+	about:       "not an array",
+	data:        `{}`,
+	expectError: `json: cannot unmarshal object into Go value of type .*`,
+}}
+
+func TestSliceUnmarshalJSONError(t *testing.T) {
+	c := qt.New(t)
+	for i, test := range sliceUnmarshalJSONErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var ms macaroon.Slice
+		err := json.Unmarshal([]byte(test.data), &ms)
+		c.Check(err, qt.ErrorMatches, test.expectError)
+	}
+}
+
+func TestSliceMarshalJSONNilMacaroon(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("id"), "", macaroon.V2)
+	_, err := macaroon.Slice{m, nil}.MarshalJSON()
+	c.Assert(err, qt.ErrorMatches, `cannot marshal macaroon 1: nil macaroon`)
+}
+
 var base64DecodeTests = []struct {
 	about       string
 	input       string
@@ -240,3 +343,390 @@ func TestBase64Decode(t *testing.T) {
 		}
 	}
 }
+
+// libmacaroonsSerialized holds the V1 macaroon from the libmacaroons
+// README as produced by its serialize function.
+const libmacaroonsSerialized = "MDAxY2xvY2F0aW9uIGh0dHA6Ly9teWJhbmsvCjAwMmNpZGVudGlmaWVyIHdlIHVzZWQgb3VyIG90aGVyIHNlY3JldCBrZXkKMDAxZGNpZCBhY2NvdW50ID0gMzczNTkyODU1OQowMDMwY2lkIHRoaXMgd2FzIGhvdyB3ZSByZW1pbmQgYXV0aCBvZiBrZXkvcHJlZAowMDUxdmlkIAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAANNuxQLgWIbR8CefBV-lJVTRbRbBsUB0u7g_8P3XncL-CY8O1KKwkRMOa120aiCoawowMDFiY2wgaHR0cDovL2F1dGgubXliYW5rLwowMDJmc2lnbmF0dXJlINJ9sv0fInYOTD2ugTfi2Pwd9sB0HBiu1LlyVr940fVcCg"
+
+func TestParseBase64(t *testing.T) {
+	c := qt.New(t)
+	data, err := macaroon.Base64Decode([]byte(libmacaroonsSerialized))
+	c.Assert(err, qt.Equals, nil)
+	for _, enc := range []*base64.Encoding{
+		base64.RawURLEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.StdEncoding,
+	} {
+		m, err := macaroon.ParseBase64(enc.EncodeToString(data))
+		c.Assert(err, qt.Equals, nil)
+		assertLibMacaroonsMacaroon(c, m)
+		c.Assert(m.Version(), qt.Equals, macaroon.V1)
+	}
+}
+
+func TestParseBase64Error(t *testing.T) {
+	c := qt.New(t)
+	_, err := macaroon.ParseBase64("!!!")
+	c.Assert(err, qt.ErrorMatches, `cannot decode base64 macaroon: illegal base64 data at input byte 0`)
+
+	_, err = macaroon.ParseBase64("")
+	c.Assert(err, qt.ErrorMatches, `empty macaroon data`)
+}
+
+func TestMarshalBase64(t *testing.T) {
+	c := qt.New(t)
+	m, err := macaroon.ParseBase64(libmacaroonsSerialized)
+	c.Assert(err, qt.Equals, nil)
+	s, err := m.MarshalBase64()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s, qt.Equals, libmacaroonsSerialized)
+
+	m.SetVersion(macaroon.V2)
+	s, err = m.MarshalBase64()
+	c.Assert(err, qt.Equals, nil)
+	m1, err := macaroon.ParseBase64(s)
+	c.Assert(err, qt.Equals, nil)
+	assertLibMacaroonsMacaroon(c, m1)
+	c.Assert(m1.Version(), qt.Equals, macaroon.V2)
+}
+
+func TestMarshalText(t *testing.T) {
+	c := qt.New(t)
+	m, err := macaroon.ParseBase64(libmacaroonsSerialized)
+	c.Assert(err, qt.Equals, nil)
+	text, err := m.MarshalText()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(text), qt.Equals, libmacaroonsSerialized)
+	b64, err := m.MarshalBase64()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(b64, qt.Equals, string(text))
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalText(text)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m1.Equal(m), qt.Equals, true)
+
+	// Padded standard encoding is also accepted.
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	err = m1.UnmarshalText([]byte(base64.StdEncoding.EncodeToString(data)))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m1.Equal(m), qt.Equals, true)
+
+	err = m1.UnmarshalText([]byte("!!!"))
+	c.Assert(err, qt.ErrorMatches, `cannot decode base64 macaroon: illegal base64 data at input byte 0`)
+	_, err = macaroon.ParseBase64("!!!")
+	c.Assert(err, qt.ErrorMatches, `cannot decode base64 macaroon: illegal base64 data at input byte 0`)
+}
+
+func TestSliceMarshalText(t *testing.T) {
+	c := qt.New(t)
+	_, ms := makeAlgorithmMacaroons(c)
+	text, err := ms.MarshalText()
+	c.Assert(err, qt.Equals, nil)
+	data, err := ms.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(text), qt.Equals, base64.RawURLEncoding.EncodeToString(data))
+
+	// Check that a slice can be used directly as a flag value.
+	var ms1 macaroon.Slice
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.TextVar(&ms1, "macaroons", macaroon.Slice(nil), "")
+	err = fs.Parse([]string{"-macaroons", string(text)})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms1.Equal(ms), qt.Equals, true)
+
+	err = ms1.UnmarshalText([]byte("!!!"))
+	c.Assert(err, qt.ErrorMatches, `cannot decode base64 macaroons: illegal base64 data at input byte 0`)
+}
+
+func TestBase32RoundTrip(t *testing.T) {
+	c := qt.New(t)
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat([]byte("a caveat"))
+		c.Assert(err, qt.Equals, nil)
+		s, err := m.MarshalBase32()
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(s, qt.Matches, `[A-Z2-7]+`)
+
+		m1, err := macaroon.ParseBase32(s)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+		c.Assert(m1.Version(), qt.Equals, vers)
+
+		// Check that a hand-transcribed form is accepted.
+		var groups []string
+		for s1 := strings.ToLower(s); len(s1) > 0; {
+			n := 4
+			if n > len(s1) {
+				n = len(s1)
+			}
+			groups = append(groups, s1[:n])
+			s1 = s1[n:]
+		}
+		m1, err = macaroon.ParseBase32(strings.Join(groups, "-") + "\n")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+	}
+}
+
+func TestParseBase32Error(t *testing.T) {
+	c := qt.New(t)
+	_, err := macaroon.ParseBase32("AB1C")
+	c.Assert(err, qt.ErrorMatches, `cannot decode base32 macaroon: illegal base32 data at input byte 2`)
+
+	_, err = macaroon.ParseBase32("")
+	c.Assert(err, qt.ErrorMatches, `empty macaroon data`)
+}
+
+func TestBase45RoundTrip(t *testing.T) {
+	c := qt.New(t)
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat([]byte("a caveat"))
+		c.Assert(err, qt.Equals, nil)
+		s, err := m.MarshalBase45()
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(s, qt.Matches, `[0-9A-Z $%*+\-./:]+`)
+
+		m1, err := macaroon.ParseBase45(s)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+		c.Assert(m1.Version(), qt.Equals, vers)
+
+		m1, err = macaroon.ParseBase45(strings.ToLower(s[:10]) + "\r\n" + s[10:])
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+	}
+}
+
+func TestParseBase45Error(t *testing.T) {
+	c := qt.New(t)
+	_, err := macaroon.ParseBase45("BB8!B")
+	c.Assert(err, qt.ErrorMatches, `cannot decode base45 macaroon: illegal base45 data at input byte 3`)
+
+	_, err = macaroon.ParseBase45("")
+	c.Assert(err, qt.ErrorMatches, `empty macaroon data`)
+}
+
+func TestUnmarshalBinaryNoCopy(t *testing.T) {
+	c := qt.New(t)
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		c.Logf("version %v", vers)
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat([]byte("a caveat"))
+		c.Assert(err, qt.Equals, nil)
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalBinaryNoCopy(data)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+
+		// The caveats refer to the original data.
+		i := bytes.Index(data, []byte("a caveat"))
+		c.Assert(i, qt.Not(qt.Equals), -1)
+		data[i] = 'A'
+		c.Assert(string(m1.Caveats()[0].Id), qt.Equals, "A caveat")
+	}
+}
+
+func TestCaveatsDoesNotAllocate(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat([]byte("a caveat"))
+	c.Assert(err, qt.Equals, nil)
+	n := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, cav := range m.Caveats() {
+			n += len(cav.Id)
+		}
+	})
+	c.Assert(allocs, qt.Equals, 0.0)
+}
+
+func TestParseBinaryLargeFields(t *testing.T) {
+	c := qt.New(t)
+	// V2 fields are not limited to 64KB.
+	long := strings.Repeat("x", 100000)
+	m := macaroon.MustNew([]byte("secret"), []byte(long), "", macaroon.V2)
+	err := m.AddFirstPartyCaveat([]byte(long))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared key"), []byte(long), "somewhere")
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	m1, _, err := macaroon.ParseBinary(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m1.Equal(m), qt.Equals, true)
+}
+
+var parserLimitsTests = []struct {
+	about       string
+	parser      macaroon.Parser
+	expectError string
+}{{
+	about: "no limits",
+}, {
+	about: "within limits",
+	parser: macaroon.Parser{
+		MaxFieldLen: 100,
+		MaxCaveats:  2,
+	},
+}, {
+	about: "too many caveats",
+	parser: macaroon.Parser{
+		MaxCaveats: 1,
+	},
+	expectError: `unmarshal v2: too many caveats \(maximum 1\)`,
+}, {
+	about: "long caveat id",
+	parser: macaroon.Parser{
+		MaxFieldLen: 10,
+	},
+	expectError: `unmarshal v2: cid field too long \(11 bytes, maximum 10\)`,
+}, {
+	about: "long verification id",
+	parser: macaroon.Parser{
+		MaxFieldLen: 20,
+	},
+	expectError: `unmarshal v2: vid field too long \(72 bytes, maximum 20\)`,
+}}
+
+func TestParserLimits(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat([]byte("a condition"))
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddThirdPartyCaveat([]byte("shared key"), []byte("3rd"), "somewhere")
+	c.Assert(err, qt.Equals, nil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	sliceData, err := macaroon.Slice{m, m}.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+
+	for i, test := range parserLimitsTests {
+		c.Logf("test %d: %s", i, test.about)
+		m1, rest, err := test.parser.ParseBinary(data)
+		ms, serr := test.parser.ParseSlice(sliceData)
+		if test.expectError != "" {
+			c.Check(err, qt.ErrorMatches, test.expectError)
+			c.Check(m1, qt.IsNil)
+			c.Check(serr, qt.ErrorMatches, `cannot unmarshal macaroon: `+test.expectError)
+			c.Check(ms, qt.IsNil)
+			continue
+		}
+		c.Assert(err, qt.Equals, nil)
+		c.Check(m1.Equal(m), qt.Equals, true)
+		c.Check(rest, qt.HasLen, 0)
+		c.Assert(serr, qt.Equals, nil)
+		c.Check(ms, qt.HasLen, 2)
+	}
+}
+
+func TestParserFieldTooLongError(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("a long identifier"), "", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	p := macaroon.Parser{
+		MaxFieldLen: 5,
+	}
+	_, _, err = p.ParseBinary(data)
+	var ferr *macaroon.FieldTooLongError
+	c.Assert(errors.As(err, &ferr), qt.Equals, true)
+	c.Assert(ferr, qt.DeepEquals, &macaroon.FieldTooLongError{
+		Field: "identifier",
+		Size:  17,
+		Limit: 5,
+	})
+}
+
+func TestParserTooManyCaveatsError(t *testing.T) {
+	c := qt.New(t)
+	p := macaroon.Parser{
+		MaxCaveats: 2,
+	}
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2, macaroon.V3} {
+		c.Logf("version %v", vers)
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "", vers)
+		for i := 0; i < 3; i++ {
+			err := m.AddFirstPartyCaveat([]byte("a caveat"))
+			c.Assert(err, qt.Equals, nil)
+		}
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+		_, _, err = p.ParseBinary(data)
+		var cerr *macaroon.TooManyCaveatsError
+		c.Assert(errors.As(err, &cerr), qt.Equals, true)
+		c.Assert(cerr, qt.DeepEquals, &macaroon.TooManyCaveatsError{
+			Limit: 2,
+		})
+		// The field limit is reported with a different type.
+		var ferr *macaroon.FieldTooLongError
+		c.Assert(errors.As(err, &ferr), qt.Equals, false)
+
+		// Exactly the maximum number of caveats is allowed.
+		m = macaroon.MustNew([]byte("secret"), []byte("some id"), "", vers)
+		for i := 0; i < 2; i++ {
+			err := m.AddFirstPartyCaveat([]byte("a caveat"))
+			c.Assert(err, qt.Equals, nil)
+		}
+		data, err = m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+		m1, _, err := p.ParseBinary(data)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+	}
+}
+
+func TestParseBinary(t *testing.T) {
+	c := qt.New(t)
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat([]byte("a caveat"))
+		c.Assert(err, qt.Equals, nil)
+		err = m.AddThirdPartyCaveat([]byte("shared key"), []byte("3rd party caveat"), "remote.com")
+		c.Assert(err, qt.Equals, nil)
+		data, err := m.MarshalBinary()
+		c.Assert(err, qt.Equals, nil)
+		data = append(data, "more payload"...)
+
+		m1, rest, err := macaroon.ParseBinary(data)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(m1.Equal(m), qt.Equals, true)
+		c.Assert(string(rest), qt.Equals, "more payload")
+
+		// The macaroon must not refer to the original data.
+		for i := range data {
+			data[i] = 0
+		}
+		c.Assert(m1.Equal(m), qt.Equals, true)
+	}
+}
+
+func TestParseBinaryError(t *testing.T) {
+	c := qt.New(t)
+	m, rest, err := macaroon.ParseBinary(nil)
+	c.Assert(err, qt.ErrorMatches, `empty macaroon data`)
+	c.Assert(m, qt.IsNil)
+	c.Assert(rest, qt.IsNil)
+
+	m, rest, err = macaroon.ParseBinary([]byte("\x02\x02"))
+	c.Assert(err, qt.ErrorMatches, `unmarshal v2: varint value extends past end of buffer`)
+	c.Assert(m, qt.IsNil)
+	c.Assert(rest, qt.IsNil)
+}
+
+func TestUnmarshalSliceError(t *testing.T) {
+	c := qt.New(t)
+	m := macaroon.MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, qt.Equals, nil)
+	var s macaroon.Slice
+	err = s.UnmarshalBinary(append(data, "garbage"...))
+	c.Assert(err, qt.ErrorMatches, `cannot unmarshal macaroon: cannot determine data format of binary-encoded macaroon`)
+}
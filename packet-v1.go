@@ -79,19 +79,31 @@ func parsePacketV1(data []byte) (packetV1, error) {
 
 // appendPacketV1 appends a packet with the given field name
 // and data to the given buffer. If the field and data were
-// too long to be encoded, it returns nil, false; otherwise
-// it returns the appended buffer.
-func appendPacketV1(buf []byte, field string, data []byte) ([]byte, bool) {
-	plen := packetV1Size(field, data)
-	if plen > maxPacketV1Len {
-		return nil, false
+// too long to be encoded, it returns a *FieldTooLongError;
+// otherwise it returns the appended buffer.
+func appendPacketV1(buf []byte, field string, data []byte) ([]byte, error) {
+	if err := checkPacketV1(field, data); err != nil {
+		return nil, err
 	}
-	buf = appendSizeV1(buf, plen)
+	buf = appendSizeV1(buf, packetV1Size(field, data))
 	buf = append(buf, field...)
 	buf = append(buf, ' ')
 	buf = append(buf, data...)
 	buf = append(buf, '\n')
-	return buf, true
+	return buf, nil
+}
+
+// checkPacketV1 returns a *FieldTooLongError if the given
+// field and data are too long to be encoded as a packet.
+func checkPacketV1(field string, data []byte) error {
+	if packetV1Size(field, data) > maxPacketV1Len {
+		return &FieldTooLongError{
+			Field: field,
+			Size:  len(data),
+			Limit: maxPacketV1Len - packetV1Size(field, nil),
+		}
+	}
+	return nil
 }
 
 func packetV1Size(field string, data []byte) int {
@@ -11,20 +11,30 @@ import (
 
 func TestAppendPacket(t *testing.T) {
 	c := qt.New(t)
-	data, ok := appendPacketV1(nil, "field", []byte("some data"))
-	c.Assert(ok, qt.Equals, true)
+	data, err := appendPacketV1(nil, "field", []byte("some data"))
+	c.Assert(err, qt.Equals, nil)
 	c.Assert(string(data), qt.Equals, "0014field some data\n")
 
-	data, ok = appendPacketV1(data, "otherfield", []byte("more and more data"))
-	c.Assert(ok, qt.Equals, true)
+	data, err = appendPacketV1(data, "otherfield", []byte("more and more data"))
+	c.Assert(err, qt.Equals, nil)
 	c.Assert(string(data), qt.Equals, "0014field some data\n0022otherfield more and more data\n")
 }
 
 func TestAppendPacketTooBig(t *testing.T) {
 	c := qt.New(t)
-	data, ok := appendPacketV1(nil, "field", make([]byte, 65532))
-	c.Assert(ok, qt.Equals, false)
+	data, err := appendPacketV1(nil, "field", make([]byte, 65532))
+	c.Assert(err, qt.DeepEquals, &FieldTooLongError{
+		Field: "field",
+		Size:  65532,
+		Limit: 65524,
+	})
+	c.Assert(err, qt.ErrorMatches, `field field too long \(65532 bytes, maximum 65524\)`)
 	c.Assert(data, qt.IsNil)
+
+	// Check that the limit is exact.
+	data, err = appendPacketV1(nil, "field", make([]byte, 65524))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(data, qt.HasLen, 0xffff)
 }
 
 var parsePacketV1Tests = []struct {
@@ -86,7 +96,7 @@ func truncate(d string) string {
 func TestAsciiHex(t *testing.T) {
 	c := qt.New(t)
 	for b := 0; b < 256; b++ {
-		n, err := strconv.ParseInt(string(b), 16, 8)
+		n, err := strconv.ParseInt(string(rune(b)), 16, 8)
 		value, ok := asciiHex(byte(b))
 		if err != nil || unicode.IsUpper(rune(b)) {
 			c.Assert(ok, qt.Equals, false)
@@ -26,10 +26,11 @@ type packetV2 struct {
 
 // parseSectionV2 parses a sequence of packets
 // in data. The sequence is terminated by a packet
-// with a field type of fieldEOS.
-func parseSectionV2(data []byte) ([]byte, []packetV2, error) {
+// with a field type of fieldEOS. The packets are
+// appended to packets, which allows the caller to
+// reuse its storage.
+func parseSectionV2(packets []packetV2, data []byte) ([]byte, []packetV2, error) {
 	prevFieldType := fieldType(-1)
-	var packets []packetV2
 	for {
 		if len(data) == 0 {
 			return nil, nil, fmt.Errorf("section extends past end of buffer")
@@ -115,7 +115,7 @@ func TestParseSectionV2(t *testing.T) {
 	c := qt.New(t)
 	for i, test := range parseSectionV2Tests {
 		c.Logf("test %d: %v", i, test.about)
-		data, ps, err := parseSectionV2([]byte(test.data))
+		data, ps, err := parseSectionV2(nil, []byte(test.data))
 		if test.expectError != "" {
 			c.Assert(err, qt.ErrorMatches, test.expectError)
 			c.Assert(data, qt.IsNil)
@@ -8,8 +8,14 @@ import (
 // and the root key used as the initial verification key.
 // This can be useful for debugging macaroon implementations.
 type Trace struct {
+	// RootKey holds the initial verification key. It is nil
+	// in traces returned by TraceCheck, which are redacted.
 	RootKey []byte
 	Ops     []TraceOp
+
+	// Algorithm holds the algorithm of the macaroon,
+	// used by all TraceHash and TraceBind operations.
+	Algorithm Algorithm
 }
 
 // Results returns the output from all operations in the Trace.
@@ -17,11 +23,17 @@ type Trace struct {
 // returned slice.
 // When a trace has resulted in a failure, the
 // last element will be nil.
+//
+// The results of a redacted trace cannot be calculated,
+// so all its elements will be nil.
 func (t Trace) Results() [][]byte {
 	r := make([][]byte, len(t.Ops))
+	if t.RootKey == nil {
+		return r
+	}
 	input := t.RootKey
 	for i, op := range t.Ops {
-		input = op.Result(input)
+		input = op.result(t.Algorithm, input)
 		r[i] = input
 	}
 	return r
@@ -37,17 +49,27 @@ type TraceOp struct {
 // Result returns the result of computing the given
 // operation with the given input data.
 // If op is TraceFail, it returns nil.
+//
+// Hash operations are computed with HMACSHA256;
+// use Trace.Results for a macaroon that uses
+// another algorithm.
 func (op TraceOp) Result(input []byte) []byte {
+	return op.result(HMACSHA256, input)
+}
+
+func (op TraceOp) result(alg Algorithm, input []byte) []byte {
 	switch op.Kind {
 	case TraceMakeKey:
 		return makeKey(input)[:]
 	case TraceHash:
 		if len(op.Data2) == 0 {
-			return keyedHash(bytesToKey(input), op.Data1)[:]
+			return keyedHash(alg, bytesToKey(input), op.Data1)[:]
 		}
-		return keyedHash2(bytesToKey(input), op.Data1, op.Data2)[:]
+		return keyedHash2(alg, bytesToKey(input), op.Data1, op.Data2)[:]
 	case TraceBind:
-		return bindForRequest(op.Data1, bytesToKey(input))[:]
+		return bindForRequest(alg, op.Data1, bytesToKey(input))[:]
+	case TraceCondition:
+		return input
 	case TraceFail:
 		return nil
 	default:
@@ -86,14 +108,21 @@ const (
 	// TraceFail represents a verification failure. If present, this will always
 	// be the last operation in a trace.
 	TraceFail
+
+	// TraceCondition represents the check of a first party caveat.
+	// Data1 holds the condition and Data2 holds the error message
+	// if the check failed. The result is the same as the input.
+	// It is only found in traces returned by TraceCheck.
+	TraceCondition
 )
 
 var traceOps = []string{
-	TraceInvalid: "invalid",
-	TraceMakeKey: "makekey",
-	TraceHash:    "hash",
-	TraceBind:    "bind",
-	TraceFail:    "fail",
+	TraceInvalid:   "invalid",
+	TraceMakeKey:   "makekey",
+	TraceHash:      "hash",
+	TraceBind:      "bind",
+	TraceFail:      "fail",
+	TraceCondition: "condition",
 }
 
 // String returns a string representation of the operation.